@@ -0,0 +1,95 @@
+package tablestruct
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaError reports the differences between a table in a database and its
+// mapping.
+type SchemaError struct {
+	Table    string
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("table %s: %s", e.Table, strings.Join(e.Problems, "; "))
+}
+
+// Check compares the tables in the database, read from its catalog, with the
+// mapping metadata. It reports missing tables and columns, columns whose type
+// or nullability differ from the metadata, and primary key columns that are
+// not in the primary key, with a *SchemaError for each table that differs.
+// Types are compared like DiffMaps does, and only for columns with a type in
+// the metadata. The generated Verify method of a mapper does the same checks
+// for its table.
+func Check(db *sql.DB, d Dialect, want Map) ([]*SchemaError, error) {
+	in, ok := d.(Introspector)
+	if !ok {
+		return nil, fmt.Errorf("dialect %s cannot introspect a database", d.Name())
+	}
+	var errs []*SchemaError
+	for _, t := range want {
+		have, _, err := in.Columns(db, t.Table)
+		if err != nil {
+			return nil, fmt.Errorf("introspecting table %s: %v", t.Table, err)
+		}
+		if problems := checkTable(t, have); len(problems) > 0 {
+			errs = append(errs, &SchemaError{Table: t.Table, Problems: problems})
+		}
+	}
+	return errs, nil
+}
+
+// checkTable compares the columns of a table in a database with its mapping.
+func checkTable(want TableMap, have []ColumnMap) []string {
+	if len(have) == 0 {
+		return []string{"missing table"}
+	}
+	var problems []string
+	for _, col := range want.Columns {
+		var prev *ColumnMap
+		for i := range have {
+			if have[i].Column == col.Column {
+				prev = &have[i]
+				break
+			}
+		}
+		switch {
+		case prev == nil:
+			problems = append(problems, "missing column "+col.Column)
+			continue
+		case col.Type != "" && canonicalType(*prev) != canonicalType(col):
+			problems = append(problems, fmt.Sprintf("column %s has type %s, want %s", col.Column, prev.Type, canonicalType(col)))
+		}
+		switch {
+		case prev.Null && !col.Null:
+			problems = append(problems, fmt.Sprintf("column %s is nullable, want NOT NULL", col.Column))
+		case !prev.Null && col.Null:
+			problems = append(problems, fmt.Sprintf("column %s is NOT NULL, want nullable", col.Column))
+		}
+		if col.PrimaryKey && !prev.PrimaryKey {
+			problems = append(problems, fmt.Sprintf("column %s is not in the primary key", col.Column))
+		}
+	}
+	return problems
+}
+
+// typeNames returns the names of the SQL types that are the same as the type
+// of the column, lacking any length or precision, or nil if it has no type.
+func typeNames(c ColumnMap) []string {
+	if c.Type == "" {
+		return nil
+	}
+	typ := canonicalType(c)
+	names := []string{typ}
+	for alias, name := range typeAliases {
+		if name == typ {
+			names = append(names, alias)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/paulsmith/tablestruct"
+	"golang.org/x/tools/go/packages"
+)
+
+// marker is the comment directive that marks struct types for metadata when
+// no struct names are given.
+const marker = "//tablestruct:map"
+
+// Generate metadata by loading and type checking the package with the import
+// path, for the named structs or, if none are named, for those marked with the
+// comment directive.
+func packageMetadata(path string, names []string, overrideTable, pkField, tagKey, dialect string) {
+	mapper, err := loadMetadata("", path, names, overrideTable, pkField, tagKey, dialect)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(mapper); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadMetadata loads the package with the import path, relative to the
+// directory dir or the current directory if it is empty, and maps its named or
+// marked structs, as packageMetadata.
+func loadMetadata(dir, path string, names []string, overrideTable, pkField, tagKey, dialect string) (tablestruct.Map, error) {
+	d, err := tablestruct.LookupDialect(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo |
+			packages.NeedImports | packages.NeedDeps,
+		Dir: dir,
+	}
+	pkgs, err := packages.Load(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	if n := packages.PrintErrors(pkgs); n > 0 {
+		return nil, fmt.Errorf("%s: %d errors loading package", path, n)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s matches %d packages, want 1", path, len(pkgs))
+	}
+	pkg := pkgs[0]
+
+	if len(names) == 0 {
+		names = markedStructs(pkg.Syntax)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no struct types in %s are marked with %s", pkg.PkgPath, marker)
+		}
+	}
+	if overrideTable != "" && len(names) > 1 {
+		return nil, fmt.Errorf("cannot override the table name of more than one struct")
+	}
+
+	var mapper tablestruct.Map
+	for _, name := range names {
+		obj, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("no type %s in %s", name, pkg.PkgPath)
+		}
+		st, ok := obj.Type().Underlying().(*types.Struct)
+		if !ok {
+			return nil, fmt.Errorf("%q must be a struct type, got %s", name, obj.Type().Underlying())
+		}
+
+		fields, err := structFields(name, st, pkg.Types, d, tagKey, "", "")
+		if err != nil {
+			return nil, err
+		}
+		tableMap, err := newTableMap(name, overrideTable, pkField, tagKey, fields)
+		if err != nil {
+			return nil, err
+		}
+		mapper = append(mapper, tableMap)
+	}
+	return mapper, nil
+}
+
+// structFields returns the fields of a struct type in package pkg to map to
+// columns. Fields of embedded struct types are flattened into it, at the path
+// of the embedded field and with the prefix of column names its tag gives.
+// path and prefix are those of st itself.
+func structFields(strct string, st *types.Struct, pkg *types.Package, d tablestruct.Dialect, tagKey, path, prefix string) ([]structField, error) {
+	var fields []structField
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get(tagKey)
+		typ, ok := varType(field.Type(), pkg, d)
+		switch {
+		case ok:
+			if field.Exported() {
+				fields = append(fields, structField{path + field.Name(), prefix, tag, typ})
+			}
+		case field.Embedded():
+			embedded, ok := field.Type().Underlying().(*types.Struct)
+			if !ok {
+				log.Printf("%s: embedded field %s%s has type %s, which cannot be flattened, skipping", strct, path, field.Name(), field.Type())
+				break
+			}
+			p, ok, err := embedPrefix(path+field.Name(), tagKey, tag)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				flat, err := structFields(strct, embedded, pkg, d, tagKey, path+field.Name()+".", prefix+p)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, flat...)
+			}
+		case field.Exported():
+			log.Printf("%s: field %s%s has type %s, which cannot be a column, skipping", strct, path, field.Name(), field.Type())
+		}
+	}
+	return fields, nil
+}
+
+// markedStructs returns the names of the types declared in the files whose doc
+// comments have the marker directive, in order.
+func markedStructs(files []*ast.File) []string {
+	var names []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				doc := spec.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				if hasMarker(doc) {
+					names = append(names, spec.Name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+func hasMarker(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// varType works out the column type of a struct field from its Go type, in
+// package pkg. Pointers are nullable. Named types map to the column type of
+// their underlying type, unless they implement sql.Scanner or driver.Valuer,
+// which makes their values opaque to tablestruct: if the underlying type is
+// not a basic type, the SQL type is left for the metadata to fill in. It
+// returns false for types that cannot be a column.
+func varType(t types.Type, pkg *types.Package, d tablestruct.Dialect) (columnType, bool) {
+	var typ columnType
+	typ.goType = types.TypeString(t, func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		typ.importPath = p.Path()
+		return p.Name()
+	})
+
+	switch x := types.Unalias(t).(type) {
+	case *types.Pointer:
+		elem, ok := varType(x.Elem(), pkg, d)
+		if !ok {
+			return typ, false
+		}
+		typ.sqlType = elem.sqlType
+		typ.null = true
+		return typ, true
+	case *types.Named:
+		obj := x.Obj()
+		if obj.Pkg() != nil {
+			if t, ok := qualifiedSQLTypes[obj.Pkg().Path()+"."+obj.Name()]; ok {
+				typ.sqlType, typ.null = t.sqlType, t.null
+				return typ, true
+			}
+		}
+		scanner := hasMethod(x, "Scan", 1, 1)
+		valuer := hasMethod(x, "Value", 0, 2)
+		if scanner != valuer {
+			log.Printf("%s implements only one of sql.Scanner and driver.Valuer", typ.goType)
+		}
+		if scanner || valuer {
+			if basic, ok := x.Underlying().(*types.Basic); ok {
+				typ.sqlType = sqlTypes[basic.Name()]
+			}
+			return typ, true
+		}
+	}
+
+	switch x := t.Underlying().(type) {
+	case *types.Basic:
+		typ.sqlType = sqlTypes[x.Name()]
+		return typ, typ.sqlType != ""
+	case *types.Slice:
+		if elem, ok := x.Elem().(*types.Basic); ok && elem.Kind() == types.Byte {
+			typ.sqlType = "blob"
+			if d == tablestruct.Postgres {
+				typ.sqlType = "bytea"
+			}
+			return typ, true
+		}
+	}
+	return typ, false
+}
+
+// hasMethod reports whether values of type t, or pointers to them, have the
+// named method with the numbers of parameters and results.
+func hasMethod(t types.Type, name string, params, results int) bool {
+	sel := types.NewMethodSet(types.NewPointer(t)).Lookup(nil, name)
+	if sel == nil {
+		return false
+	}
+	sig := sel.Type().(*types.Signature)
+	return sig.Params().Len() == params && sig.Results().Len() == results
+}
@@ -1,22 +1,36 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/paulsmith/tablestruct"
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s [-package=<package>] gen\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "   or: %s [-package=<package>] [-table=<table>] [-pk=<field>] metadata <structname>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s [-package=<package>] [-dialect=<dialect>] [-go=<version>] gen\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-dialect=<dialect>] [-table=<table>] [-pk=<field>] [-tag=<key>] metadata <structname>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s -load=<package> [-dialect=<dialect>] [-table=<table>] [-pk=<field>] [-tag=<key>] metadata [<structname>...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-package=<package>] [-tags=<key>,...] structs\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-dialect=<dialect>] schema\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "   or: %s [-package=<package>] support\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-dialect=<dialect>] [-dsn=<dsn>] introspect [<table>...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-dialect=<dialect>] [-dsn=<dsn>] [-migrations=<dir>] [-name=<name>] diff\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s [-dialect=<dialect>] [-dsn=<dsn>] check\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "option defaults:\n")
 	flag.PrintDefaults()
 }
@@ -38,96 +52,466 @@ func (c commands) invoke(name string) {
 }
 
 // Generate Go code from mapping metadata.
-func gen(pkg string) {
+func gen(pkg, dialect, goVersion string) {
 	mapper, err := tablestruct.NewMap(os.Stdin)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	code := tablestruct.NewCode()
-	code.Gen(mapper, pkg, os.Stdout)
+	if dialect != "" {
+		d, err := tablestruct.LookupDialect(dialect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		code.Dialect = d
+	}
+	code.GoVersion = goVersion
+	if err := code.Gen(mapper, pkg, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Generate Go struct types from mapping metadata.
+func structs(pkg, tags string) {
+	mapper, err := tablestruct.NewMap(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s tablestruct.Structs
+	if tags != "" {
+		s.Tags = strings.Split(tags, ",")
+	}
+	if err := s.Gen(mapper, pkg, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Generate SQL that creates the tables of mapping metadata.
+func schema(dialect string) {
+	mapper, err := tablestruct.NewMap(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s tablestruct.Schema
+	if dialect != "" {
+		d, err := tablestruct.LookupDialect(dialect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Dialect = d
+	}
+	if err := s.Gen(mapper, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// drivers are the names of the database/sql drivers for each dialect.
+var drivers = map[string]string{
+	"postgres": "postgres",
+	"mysql":    "mysql",
+	"sqlite":   "sqlite3",
+}
+
+// Connect to a database with the driver for the dialect.
+func openDB(dialect, dsn string) (*sql.DB, tablestruct.Dialect) {
+	d, err := tablestruct.LookupDialect(dialect)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := sql.Open(drivers[d.Name()], dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db, d
+}
+
+// Generate metadata by inspecting database tables.
+func introspect(dialect, dsn string, tables []string) {
+	db, d := openDB(dialect, dsn)
+	defer db.Close()
+
+	mapper, err := tablestruct.Introspect(db, d, tables...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(mapper); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Generate a migration from the database's schema to that of mapping metadata.
+func diff(dialect, dsn, dir, name string) {
+	mapper, err := tablestruct.NewMap(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, d := openDB(dialect, dsn)
+	defer db.Close()
+
+	migration, err := tablestruct.Diff(db, d, *mapper)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, step := range migration.Up {
+		if step.Destructive {
+			log.Printf("destructive: %s", step.SQL)
+		}
+	}
+
+	if dir == "" {
+		if err := migration.WriteUp(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if migration.Empty() {
+		log.Print("no changes")
+		return
+	}
+	up, down, err := migration.WriteFiles(dir, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s and %s", up, down)
+}
+
+// Check the database's schema against mapping metadata, exiting with status 1
+// if they differ.
+func check(dialect, dsn string) {
+	mapper, err := tablestruct.NewMap(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, d := openDB(dialect, dsn)
+	defer db.Close()
+
+	errs, err := tablestruct.Check(db, d, *mapper)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
 }
 
 // Generate metadata by inspecting a struct.
-func structMetadata(typ, overrideTable, pkField string) {
+func structMetadata(typ, overrideTable, pkField, tagKey, dialect string) {
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, "", os.Stdin, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var mapper tablestruct.Map
+	d, err := tablestruct.LookupDialect(dialect)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Find the struct type named `typ' in the AST.
-	ast.Inspect(f, func(n ast.Node) bool {
-		switch x := n.(type) {
-		case *ast.TypeSpec:
-			if x.Name.Name != typ {
-				break
-			}
+	// Import paths by the names the file refers to them by.
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
 
-			structType, ok := x.Type.(*ast.StructType)
-			if !ok {
+	// Struct types declared in the file, for the struct named `typ' and those
+	// it embeds.
+	structTypes := make(map[string]*ast.StructType)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if x, ok := n.(*ast.TypeSpec); ok {
+			if structType, ok := x.Type.(*ast.StructType); ok {
+				structTypes[x.Name.Name] = structType
+			} else if x.Name.Name == typ {
 				log.Fatalf("%q must be a struct type, got %T", typ, x.Type)
 			}
+		}
+		return true
+	})
 
-			tableName := overrideTable
-			if tableName == "" {
-				tableName = tablestruct.StructToTable(typ)
-			}
+	var mapper tablestruct.Map
+	if structType, ok := structTypes[typ]; ok {
+		fields := astFields(structType, structTypes, imports, d, tagKey, "", "")
+		tableMap, err := newTableMap(typ, overrideTable, pkField, tagKey, fields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mapper = tablestruct.Map{tableMap}
+	}
 
-			tableMap := tablestruct.TableMap{
-				Struct:  typ,
-				Table:   tableName,
-				Columns: make([]tablestruct.ColumnMap, 0, len(structType.Fields.List)),
-				// TODO(paulsmith): allow override
-				AutoPK: false,
-			}
+	if err := json.NewEncoder(os.Stdout).Encode(mapper); err != nil {
+		log.Fatal(err)
+	}
+}
 
-			for i, field := range structType.Fields.List {
-				if field.Names == nil {
-					continue
-				}
-				name := field.Names[0]
-				if !name.IsExported() {
-					continue
-				}
-				ident, ok := field.Type.(*ast.Ident)
-				if !ok {
-					log.Printf("field %d %q is anonymous type dec, skipping", i, ident)
-					continue
-				}
-				column := tablestruct.ColumnMap{
-					Field:  name.Name,
-					Column: tablestruct.FieldToColumn(name.Name),
-					//Type: tablestruct.StructTypeToColumnType(fieldType),
-					Null:       false,
-					PrimaryKey: name.Name == pkField,
-				}
-				tableMap.Columns = append(tableMap.Columns, column)
+// astFields returns the fields of a struct type to map to columns. Fields of
+// embedded struct types declared in the same file, in structTypes, are
+// flattened into it, at the path of the embedded field and with the prefix of
+// column names its tag gives. path and prefix are those of structType itself.
+func astFields(structType *ast.StructType, structTypes map[string]*ast.StructType, imports map[string]string, d tablestruct.Dialect, tagKey, path, prefix string) []structField {
+	var fields []structField
+	for i, field := range structType.Fields.List {
+		var tag string
+		if field.Tag != nil {
+			lit, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				log.Fatalf("field %d: %v", i, err)
+			}
+			tag = reflect.StructTag(lit).Get(tagKey)
+		}
+		if field.Names == nil {
+			ident, ok := field.Type.(*ast.Ident)
+			if !ok || structTypes[ident.Name] == nil {
+				log.Printf("embedded field %s%s is not a struct type declared in the file, skipping; see -load", path, types.ExprString(field.Type))
+				continue
+			}
+			p, ok, err := embedPrefix(path+ident.Name, tagKey, tag)
+			if err != nil {
+				log.Fatal(err)
 			}
+			if ok {
+				fields = append(fields, astFields(structTypes[ident.Name], structTypes, imports, d, tagKey, path+ident.Name+".", prefix+p)...)
+			}
+			continue
+		}
+		typ, ok := fieldType(field.Type, imports, d)
+		if !ok {
+			log.Printf("field %s%s has type %s, which cannot be a column, skipping", path, field.Names[0], types.ExprString(field.Type))
+			continue
+		}
+		for _, name := range field.Names {
+			if name.IsExported() {
+				fields = append(fields, structField{path + name.Name, prefix, tag, typ})
+			}
+		}
+	}
+	return fields
+}
+
+// embedPrefix returns the prefix of the column names of the fields of an
+// embedded struct, following the value of the embedded field's struct tag with
+// key tagKey. The tag is the prefix, or "-" to skip the embedded struct. It
+// returns false if the struct is skipped, and an error for a tag with options.
+func embedPrefix(field, tagKey, tag string) (string, bool, error) {
+	if tag == "-" {
+		return "", false, nil
+	}
+	if strings.Contains(tag, ",") {
+		return "", false, fmt.Errorf("field %s: %s tag options are not supported on embedded structs", field, tagKey)
+	}
+	return tag, true, nil
+}
 
-			mapper = tablestruct.Map{tableMap}
+// structField is a field of a struct to map to a column.
+type structField struct {
+	name   string // or dotted path to the field of an embedded struct
+	prefix string // of the column name
+	tag    string // value of the struct tag with the configured key
+	typ    columnType
+}
+
+// newTableMap maps a struct with the fields to a table, named after the struct
+// unless table is set.
+func newTableMap(strct, table, pkField, tagKey string, fields []structField) (tablestruct.TableMap, error) {
+	if table == "" {
+		table = tablestruct.StructToTable(strct)
+	}
+	tableMap := tablestruct.TableMap{
+		Struct:  strct,
+		Table:   table,
+		Columns: make([]tablestruct.ColumnMap, 0, len(fields)),
+	}
+	tagged := false // whether tags mark the primary key
+	for _, field := range fields {
+		column, auto, ok, err := fieldColumn(field.name, tagKey, field.tag)
+		if err != nil {
+			return tableMap, err
+		}
+		if !ok {
+			continue
+		}
+		column.Column = field.prefix + column.Column
+		column.Type = field.typ.sqlType
+		column.Null = column.Null || field.typ.null
+		if column.GoType() != field.typ.goType {
+			column.GoTypeName = field.typ.goType
+			column.Import = field.typ.importPath
+		}
+		tagged = tagged || column.PrimaryKey
+		tableMap.AutoPK = tableMap.AutoPK || auto
+		tableMap.Columns = append(tableMap.Columns, column)
+	}
+	if !tagged {
+		for i := range tableMap.Columns {
+			tableMap.Columns[i].PrimaryKey = tableMap.Columns[i].Field == pkField
 		}
+	}
+	return tableMap, nil
+}
 
-		return true
-	})
+// columnType is what the Go type of a struct field says about its column.
+type columnType struct {
+	sqlType    string // empty if unknown
+	null       bool
+	goType     string
+	importPath string // of the package goType refers to, if any
+}
 
-	if err := json.NewEncoder(os.Stdout).Encode(mapper); err != nil {
-		log.Fatal(err)
+// sqlTypes are the SQL types of columns for predeclared Go types.
+var sqlTypes = map[string]string{
+	"int":     "integer",
+	"int8":    "smallint",
+	"int16":   "smallint",
+	"int32":   "integer",
+	"int64":   "bigint",
+	"uint":    "bigint",
+	"byte":    "smallint",
+	"rune":    "integer",
+	"uint8":   "smallint",
+	"uint16":  "integer",
+	"uint32":  "bigint",
+	"uint64":  "bigint",
+	"float32": "real",
+	"float64": "double precision",
+	"bool":    "boolean",
+	"string":  "text",
+}
+
+// qualifiedSQLTypes are the SQL types of columns for Go types from other
+// packages, by import path and type name, and whether they are nullable.
+var qualifiedSQLTypes = map[string]columnType{
+	"time.Time":                      {sqlType: "timestamp"},
+	"database/sql.NullString":        {sqlType: "text", null: true},
+	"database/sql.NullInt64":         {sqlType: "bigint", null: true},
+	"database/sql.NullInt32":         {sqlType: "integer", null: true},
+	"database/sql.NullInt16":         {sqlType: "smallint", null: true},
+	"database/sql.NullFloat64":       {sqlType: "double precision", null: true},
+	"database/sql.NullBool":          {sqlType: "boolean", null: true},
+	"database/sql.NullTime":          {sqlType: "timestamp", null: true},
+	"github.com/google/uuid.UUID":    {sqlType: "uuid"},
+	"github.com/gofrs/uuid.UUID":     {sqlType: "uuid"},
+	"github.com/satori/go.uuid.UUID": {sqlType: "uuid"},
+}
+
+// fieldType works out the column type of a struct field from its Go type, in
+// a file that imports packages by the names in imports. Pointers are nullable.
+// It returns false for types that cannot be a column, like struct literals,
+// maps, functions, and slices and arrays other than []byte.
+func fieldType(expr ast.Expr, imports map[string]string, d tablestruct.Dialect) (columnType, bool) {
+	typ := columnType{goType: types.ExprString(expr)}
+	switch x := expr.(type) {
+	case *ast.Ident:
+		typ.sqlType = sqlTypes[x.Name]
+	case *ast.SelectorExpr:
+		pkg, ok := x.X.(*ast.Ident)
+		if !ok {
+			return typ, false
+		}
+		typ.importPath = imports[pkg.Name]
+		t := qualifiedSQLTypes[typ.importPath+"."+x.Sel.Name]
+		typ.sqlType, typ.null = t.sqlType, t.null
+	case *ast.StarExpr:
+		elem, ok := fieldType(x.X, imports, d)
+		if !ok {
+			return typ, false
+		}
+		typ.sqlType, typ.importPath = elem.sqlType, elem.importPath
+		typ.null = true
+	case *ast.ArrayType:
+		elem, ok := x.Elt.(*ast.Ident)
+		if !ok || x.Len != nil || elem.Name != "byte" {
+			return typ, false
+		}
+		typ.sqlType = "blob"
+		if d == tablestruct.Postgres {
+			typ.sqlType = "bytea"
+		}
+	default:
+		return typ, false
+	}
+	return typ, true
+}
+
+// defaultTagKey is the key of struct tags read for column names and options,
+// unless the -tag flag gives another.
+const defaultTagKey = "db"
+
+// fieldColumn maps a struct field to a column, following the value of its
+// struct tag with key tagKey, if any. The tag is the column name, "-" to skip
+// the field, followed by comma-separated options: "pk" for a primary key
+// column, "auto" for one whose values the database generates, and "null" for
+// a nullable column. It returns whether the primary key is automatically
+// generated, false if the field is skipped, and an error for an unknown
+// option. Tags of other keys than defaultTagKey, like json, have options of
+// their own, like omitempty, so unknown ones are ignored.
+func fieldColumn(field, tagKey, tag string) (col tablestruct.ColumnMap, auto, ok bool, err error) {
+	if tag == "-" {
+		return col, false, false, nil
+	}
+	opts := strings.Split(tag, ",")
+	col = tablestruct.ColumnMap{
+		Field:  field,
+		Column: opts[0],
+	}
+	if col.Column == "" {
+		col.Column = tablestruct.FieldToColumn(col.FieldName())
 	}
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "pk":
+			col.PrimaryKey = true
+		case "auto":
+			col.PrimaryKey = true
+			auto = true
+		case "null":
+			col.Null = true
+		default:
+			if tagKey != defaultTagKey {
+				continue
+			}
+			return col, false, false, fmt.Errorf("field %s: unknown %s tag option %q", field, tagKey, opt)
+		}
+	}
+	return col, auto, true, nil
 }
 
 // Generate supporting Go code.
 func support(pkg string) {
-	tablestruct.GenSupport(os.Stdout, pkg)
+	if err := tablestruct.GenSupport(os.Stdout, pkg); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
 	var (
 		pkg           = flag.String("package", "main", "package of generated code")
 		overrideTable = flag.String("table", "", "override table name")
-		pkField       = flag.String("pk", "ID", "name of struct field of primary key")
+		pkField       = flag.String("pk", "ID", "name of struct field of primary key, unless struct tags mark one")
+		tagKey        = flag.String("tag", defaultTagKey, "key of struct tags with column names and options")
+		dialect       = flag.String("dialect", "", "SQL dialect of generated code or schema, overriding metadata, or of the database ("+strings.Join(tablestruct.DialectNames(), ", ")+")")
+		tags          = flag.String("tags", "", "comma-separated keys of struct tags of generated struct fields, like json,db")
+		dsn           = flag.String("dsn", "", "data source name of the database to connect to, in the format of the dialect's driver")
+		migrations    = flag.String("migrations", "", "directory to write numbered up and down migration files to, rather than printing the up migration")
+		name          = flag.String("name", "tablestruct", "name of migration files")
+		load          = flag.String("load", "", "import path of a package to load and type check for metadata, rather than parsing a file from stdin")
+		goVersion     = flag.String("go", "", "Go version of the generated code, like 1.23, enabling methods that need it")
 	)
 
 	flag.Usage = usage
@@ -140,17 +524,26 @@ func main() {
 	}
 
 	cmds := commands{
-		{"gen", func() { gen(*pkg) }},
+		{"gen", func() { gen(*pkg, *dialect, *goVersion) }},
 		{"metadata", func() {
+			if *load != "" {
+				packageMetadata(*load, flag.Args()[1:], *overrideTable, *pkField, *tagKey, *dialect)
+				return
+			}
 			if flag.Arg(1) == "" {
 				fmt.Fprintf(os.Stderr, "must supply name of struct type\n")
 				flag.Usage()
 				os.Exit(1)
 			}
-			structMetadata(flag.Arg(1), *overrideTable, *pkField)
+			structMetadata(flag.Arg(1), *overrideTable, *pkField, *tagKey, *dialect)
 		},
 		},
+		{"structs", func() { structs(*pkg, *tags) }},
+		{"schema", func() { schema(*dialect) }},
 		{"support", func() { support(*pkg) }},
+		{"introspect", func() { introspect(*dialect, *dsn, flag.Args()[1:]) }},
+		{"diff", func() { diff(*dialect, *dsn, *migrations, *name) }},
+		{"check", func() { check(*dialect, *dsn) }},
 	}
 
 	cmds.invoke(flag.Arg(0))
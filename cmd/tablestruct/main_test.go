@@ -0,0 +1,322 @@
+package main
+
+import (
+	"go/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paulsmith/tablestruct"
+)
+
+func TestFieldColumn(t *testing.T) {
+	var tests = []struct {
+		field, key, tag string
+		want            tablestruct.ColumnMap
+		auto, ok        bool
+		err             string
+	}{
+		{"Name", "db", "", tablestruct.ColumnMap{Field: "Name", Column: "name"}, false, true, ""},
+		{"Name", "db", "full_name", tablestruct.ColumnMap{Field: "Name", Column: "full_name"}, false, true, ""},
+		{"ID", "db", "id,pk", tablestruct.ColumnMap{Field: "ID", Column: "id", PrimaryKey: true}, false, true, ""},
+		{"ID", "db", ",auto", tablestruct.ColumnMap{Field: "ID", Column: "id", PrimaryKey: true}, true, true, ""},
+		{"Nick", "db", "nick,null", tablestruct.ColumnMap{Field: "Nick", Column: "nick", Null: true}, false, true, ""},
+		{"Code", "db", "code,pk,null", tablestruct.ColumnMap{Field: "Code", Column: "code", PrimaryKey: true, Null: true}, false, true, ""},
+		{"Address.Street", "db", "", tablestruct.ColumnMap{Field: "Address.Street", Column: "street"}, false, true, ""},
+		{"Secret", "db", "-", tablestruct.ColumnMap{}, false, false, ""},
+		{"Name", "db", "name,unique", tablestruct.ColumnMap{}, false, false, `field Name: unknown db tag option "unique"`},
+		{"Name", "db", "name,", tablestruct.ColumnMap{}, false, false, `field Name: unknown db tag option ""`},
+		{"Name", "db", "name, pk", tablestruct.ColumnMap{}, false, false, `field Name: unknown db tag option " pk"`},
+		{"Name", "json", "name,omitempty", tablestruct.ColumnMap{Field: "Name", Column: "name"}, false, true, ""},
+		{"ID", "json", "id,string,pk", tablestruct.ColumnMap{Field: "ID", Column: "id", PrimaryKey: true}, false, true, ""},
+		{"Nick", "json", ",omitempty,null", tablestruct.ColumnMap{Field: "Nick", Column: "nick", Null: true}, false, true, ""},
+	}
+	for _, test := range tests {
+		col, auto, ok, err := fieldColumn(test.field, test.key, test.tag)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %q: want error %q, got %v", test.field, test.tag, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", test.field, test.tag, err)
+			continue
+		}
+		if ok != test.ok {
+			t.Errorf("%s %q: want ok %t, got %t", test.field, test.tag, test.ok, ok)
+		}
+		if ok && !reflect.DeepEqual(col, test.want) {
+			t.Errorf("%s %q: want %+v, got %+v", test.field, test.tag, test.want, col)
+		}
+		if auto != test.auto {
+			t.Errorf("%s %q: want auto %t, got %t", test.field, test.tag, test.auto, auto)
+		}
+	}
+}
+
+func TestNewTableMap(t *testing.T) {
+	var (
+		integer = columnType{sqlType: "integer", goType: "int"}
+		text    = columnType{sqlType: "text", goType: "string"}
+		ptrText = columnType{sqlType: "text", null: true, goType: "*string"}
+	)
+	var tests = []struct {
+		name      string
+		table, pk string
+		fields    []structField
+		want      tablestruct.TableMap
+		err       string
+	}{
+		{
+			name: "untagged",
+			pk:   "ID",
+			fields: []structField{
+				{name: "ID", typ: integer},
+				{name: "FullName", typ: text},
+			},
+			want: tablestruct.TableMap{Struct: "Person", Table: "person", Columns: []tablestruct.ColumnMap{
+				{Field: "ID", Column: "id", Type: "integer", PrimaryKey: true, GoTypeName: "int"},
+				{Field: "FullName", Column: "full_name", Type: "text"},
+			}},
+		},
+		{
+			name:  "tagged",
+			table: "people",
+			pk:    "ID",
+			fields: []structField{
+				{name: "ID", tag: "person_id,auto", typ: integer},
+				{name: "Key", tag: ",pk", typ: text},
+				{name: "Name", tag: "name,null", typ: text},
+				{name: "Nick", typ: ptrText},
+				{name: "Secret", tag: "-", typ: text},
+			},
+			want: tablestruct.TableMap{Struct: "Person", Table: "people", AutoPK: true, Columns: []tablestruct.ColumnMap{
+				{Field: "ID", Column: "person_id", Type: "integer", PrimaryKey: true, GoTypeName: "int"},
+				{Field: "Key", Column: "key", Type: "text", PrimaryKey: true},
+				{Field: "Name", Column: "name", Type: "text", Null: true, GoTypeName: "string"},
+				{Field: "Nick", Column: "nick", Type: "text", Null: true, GoTypeName: "*string"},
+			}},
+		},
+		{
+			name: "embedded",
+			pk:   "ID",
+			fields: []structField{
+				{name: "ID", typ: integer},
+				{name: "Home.Street", prefix: "home_", tag: "", typ: text},
+				{name: "Home.Zip", prefix: "home_", tag: "postcode", typ: text},
+			},
+			want: tablestruct.TableMap{Struct: "Person", Table: "person", Columns: []tablestruct.ColumnMap{
+				{Field: "ID", Column: "id", Type: "integer", PrimaryKey: true, GoTypeName: "int"},
+				{Field: "Home.Street", Column: "home_street", Type: "text"},
+				{Field: "Home.Zip", Column: "home_postcode", Type: "text"},
+			}},
+		},
+		{
+			name: "malformed",
+			fields: []structField{
+				{name: "ID", tag: "id,primary", typ: integer},
+			},
+			err: `field ID: unknown db tag option "primary"`,
+		},
+	}
+	for _, test := range tests {
+		tableMap, err := newTableMap("Person", test.table, test.pk, "db", test.fields)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: want error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tableMap, test.want) {
+			t.Errorf("%s: want %+v, got %+v", test.name, test.want, tableMap)
+		}
+	}
+}
+
+func TestFieldType(t *testing.T) {
+	imports := map[string]string{"time": "time", "sql": "database/sql"}
+	var tests = []struct {
+		expr string
+		want columnType
+		ok   bool
+	}{
+		{"string", columnType{sqlType: "text", goType: "string"}, true},
+		{"*int64", columnType{sqlType: "bigint", null: true, goType: "*int64"}, true},
+		{"time.Time", columnType{sqlType: "timestamp", goType: "time.Time", importPath: "time"}, true},
+		{"sql.NullString", columnType{sqlType: "text", null: true, goType: "sql.NullString", importPath: "database/sql"}, true},
+		{"Status", columnType{goType: "Status"}, true},
+		{"[]byte", columnType{sqlType: "bytea", goType: "[]byte"}, true},
+		{"*[]byte", columnType{sqlType: "bytea", null: true, goType: "*[]byte"}, true},
+		{"[]string", columnType{}, false},
+		{"[]int64", columnType{}, false},
+		{"[16]byte", columnType{}, false},
+		{"[][]byte", columnType{}, false},
+		{"*[]string", columnType{}, false},
+		{"map[string]string", columnType{}, false},
+		{"struct{ X int }", columnType{}, false},
+	}
+	for _, test := range tests {
+		expr, err := parser.ParseExpr(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, ok := fieldType(expr, imports, tablestruct.Postgres)
+		if ok != test.ok {
+			t.Errorf("%s: want ok %t, got %t", test.expr, test.ok, ok)
+			continue
+		}
+		if ok && typ != test.want {
+			t.Errorf("%s: want %+v, got %+v", test.expr, test.want, typ)
+		}
+	}
+}
+
+// loadSource is a package for loadMetadata to load.
+const loadSource = `package m
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+type Timestamp = time.Time
+
+// Status is a string that implements sql.Scanner and driver.Valuer.
+type Status string
+
+func (s *Status) Scan(src interface{}) error { return nil }
+
+func (s Status) Value() (driver.Value, error) { return string(s), nil }
+
+// Point is a struct that implements sql.Scanner and driver.Valuer.
+type Point struct{ X, Y float64 }
+
+func (p *Point) Scan(src interface{}) error { return nil }
+
+func (p Point) Value() (driver.Value, error) { return nil, nil }
+
+type Audit struct {
+	CreatedAt time.Time
+	UpdatedBy *string
+}
+
+//tablestruct:map
+type Person struct {
+	ID       int64 ` + "`db:\",auto\"`" + `
+	Name     string
+	Born     Timestamp
+	Status   Status
+	Location Point
+	Tags     []string
+	Audit    ` + "`db:\"audit_\"`" + `
+	secret   string
+}
+
+type Unmarked struct{ ID int64 }
+
+type BadEmbed struct {
+	ID    int64
+	Audit ` + "`db:\"audit_,null\"`" + `
+}
+
+type (
+	//tablestruct:map
+	Tag struct {
+		Name string ` + "`db:\",pk\"`" + `
+	}
+	Other struct{ ID int64 }
+)
+`
+
+func TestLoadMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tablestruct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   loadSource,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mapper, err := loadMetadata(dir, ".", nil, "", "ID", "db", "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapper) != 2 {
+		t.Fatalf("want 2 marked structs, got %+v", mapper)
+	}
+	// The alias is spelled as itself or as time.Time depending on whether
+	// the Go version type checks aliases as types of their own.
+	born := &mapper[0].Columns[2]
+	if born.GoTypeName != "Timestamp" && born.GoTypeName != "" {
+		t.Errorf("want Timestamp or no Go type for the alias, got %q", born.GoTypeName)
+	}
+	born.GoTypeName, born.Import = "", ""
+	want := tablestruct.Map{{
+		Struct: "Person",
+		Table:  "person",
+		AutoPK: true,
+		Columns: []tablestruct.ColumnMap{
+			{Field: "ID", Column: "id", Type: "bigint", PrimaryKey: true},
+			{Field: "Name", Column: "name", Type: "text"},
+			{Field: "Born", Column: "born", Type: "timestamp"},
+			{Field: "Status", Column: "status", Type: "text", GoTypeName: "Status"},
+			{Field: "Location", Column: "location", GoTypeName: "Point"},
+			{Field: "Audit.CreatedAt", Column: "audit_created_at", Type: "timestamp"},
+			{Field: "Audit.UpdatedBy", Column: "audit_updated_by", Type: "text", Null: true, GoTypeName: "*string"},
+		},
+	}, {
+		Struct: "Tag",
+		Table:  "tag",
+		Columns: []tablestruct.ColumnMap{
+			{Field: "Name", Column: "name", Type: "text", PrimaryKey: true},
+		},
+	}}
+	if !reflect.DeepEqual(mapper, want) {
+		t.Errorf("want %+v, got %+v", want, mapper)
+	}
+
+	mapper, err = loadMetadata(dir, ".", []string{"Unmarked"}, "things", "ID", "db", "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = tablestruct.Map{{
+		Struct: "Unmarked",
+		Table:  "things",
+		Columns: []tablestruct.ColumnMap{
+			{Field: "ID", Column: "id", Type: "bigint", PrimaryKey: true},
+		},
+	}}
+	if !reflect.DeepEqual(mapper, want) {
+		t.Errorf("want %+v, got %+v", want, mapper)
+	}
+
+	var errTests = []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"Nonesuch"}, "no type Nonesuch in "},
+		{[]string{"Status"}, `"Status" must be a struct type, got string`},
+		{[]string{"BadEmbed"}, "field Audit: db tag options are not supported on embedded structs"},
+	}
+	for _, test := range errTests {
+		_, err := loadMetadata(dir, ".", test.names, "", "ID", "db", "postgres")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%v: want error %q, got %v", test.names, test.want, err)
+		}
+	}
+}
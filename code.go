@@ -10,10 +10,21 @@ import (
 	"log"
 	"strings"
 	"text/template"
+
+	"bitbucket.org/pkg/inflect"
 )
 
 // Code generates Go code that maps database tables to structs.
 type Code struct {
+	// Dialect, if set, is the SQL dialect of the generated code for every
+	// table, overriding the dialect in the mapping metadata.
+	Dialect Dialect
+	// GoVersion is the version of Go the generated code is built with, like
+	// "1.23". Methods that need a later version than the oldest tablestruct
+	// supports, such as Seq returning a range-over-func iterator, are only
+	// generated if it is recent enough. Empty means the oldest version.
+	GoVersion string
+
 	buf  *bytes.Buffer
 	tmpl *template.Template
 }
@@ -32,21 +43,115 @@ func (c *Code) write(format string, param ...interface{}) {
 }
 
 type tableMapTmpl struct {
-	Mapper       TableMap
-	MapperType   string
-	MapperFields []string
-	VarName      string
-	StructType   string
-	ColumnList   string
-	Table        string
-	Fields       []string
-	UpdateList   string
-	UpdateCount  int
-	InsertList   string
-}
-
-// Gen generates Go code for a set of table mappings.
-func (c *Code) Gen(mapper *Map, pkg string, out io.Writer) {
+	Mapper         TableMap
+	Dialect        Dialect
+	MapperType     string
+	MapperFields   []string
+	VarName        string
+	StructType     string
+	ColumnList     string
+	Table          string
+	QuotedTable    string
+	Fields         []string
+	UpdateList     string
+	UpdateFields   []string
+	Version        string // field of the version column, if any
+	UpdateCount    int
+	InsertList     string
+	SelectSQL      string
+	SQL            map[string]string
+	BatchSize      int
+	BatchInsert    batchInsertTmpl
+	KeyArgs        string // Go expressions for the key in variable key
+	ObjKeyArgs     string // Go expressions for the key of variable obj
+	ObjReturnDests string // Go expressions for pointers to the key and version of obj
+	BelongsTo      []belongsToTmpl
+	HasMany        []hasManyTmpl
+	VerifySQL      string // catalog query, if the dialect has one
+	VerifyColumns  []verifyColumnTmpl
+	Upsert         *upsertTmpl       // nil if the mapper has no Upsert method
+	DeleteSQL      string            // lacking a WHERE clause
+	Scope          string            // leaves out soft-deleted rows, if any
+	WithDeleted    map[string]string // read statements including them
+	DeleteKeys     deleteKeysTmpl
+	RangeFunc      bool      // whether to generate iter.Seq2 iterators
+	Page           *pageTmpl // nil if the mapper has no Page method
+}
+
+// verifyColumnTmpl is what Verify checks of a column.
+type verifyColumnTmpl struct {
+	Name       string
+	Types      string // Go expression for the names of the SQL type
+	Null       bool
+	PrimaryKey bool
+}
+
+// belongsToTmpl has what the loaders of the struct referenced by a column
+// need.
+type belongsToTmpl struct {
+	Name       string // of the relationship, as in Load<Name>
+	Plural     string // of the relationship, as in the batched Load<Plural>
+	Field      string // referencing field
+	Struct     string // referenced struct type
+	MapperType string // mapper type of the referenced struct
+	KeyField   string // referenced field
+	Table      string // referenced table
+	SQL        string // selects the referenced row
+	InPrefix   string // selects referenced rows, up to "IN ("
+	BatchSize  int    // keys per IN list
+}
+
+// hasManyTmpl has what the loader of the structs that reference a struct
+// through a column needs.
+type hasManyTmpl struct {
+	Name       string // of the loader
+	KeyField   string // referenced field
+	Struct     string // referencing struct type
+	MapperType string // mapper type of the referencing struct
+	SQL        string // selects the referencing rows
+}
+
+// batchInsertTmpl has the parts of the multi-row INSERT statement that
+// InsertMany builds at run time.
+type batchInsertTmpl struct {
+	Prefix string // up to and including VALUES
+	Row    string // Go expression for the nth row's value list
+	Suffix string // following the value lists
+}
+
+// deleteKeysTmpl has the parts of the DELETE statements DeleteMany and
+// DeleteByKeys build at run time.
+type deleteKeysTmpl struct {
+	Prefix    string // up to the first key
+	Row       string // Go expression for the key at 0-based argument pos
+	Sep       string // between keys
+	Suffix    string // following the last key
+	Width     int    // number of arguments per key
+	BatchSize int    // maximum number of arguments per statement
+}
+
+// upsertTmpl holds the SQL of the Upsert and UpsertMany methods.
+type upsertTmpl struct {
+	SQL     string // single-row statement
+	Suffix  string // following the value lists of a multi-row INSERT
+	KeySQL  string // selects the key and version of the upserted row, by KeyArgs
+	KeyArgs string // Go expressions for the conflict columns of variable obj
+	RefKeys string // KeyArgs, each converted by refKey
+}
+
+// pageTmpl holds what the Page method needs to page through rows by their
+// page key.
+type pageTmpl struct {
+	SQL      string // selects the rows following a page key
+	FirstSQL string // selects the first rows
+	Args     string // Go expressions for the page key of variable obj
+	KeyArgs  string // Go expressions for the page key of variable key
+	Dests    string // Go expressions for pointers to the page key of key
+}
+
+// Gen generates Go code for a set of table mappings. Errors in the mapping
+// metadata are reported as a *MapError.
+func (c *Code) Gen(mapper *Map, pkg string, out io.Writer) error {
 	data := struct {
 		Package   string
 		Imports   []importSpec
@@ -55,49 +160,294 @@ func (c *Code) Gen(mapper *Map, pkg string, out io.Writer) {
 		Package: pkg,
 		Imports: mapper.Imports(),
 	}
+	if c.rangeFunc() {
+		data.Imports = append(data.Imports, importSpec{"iter", ""})
+	}
 
 	for i, tableMap := range *mapper {
 		log.Printf("%d: generating map %s -> %s", i, tableMap.Table, tableMap.Struct)
-		data.TableMaps = append(data.TableMaps, c.genMapper(tableMap))
+		dialect, err := c.dialect(tableMap)
+		if err != nil {
+			return &MapError{Table: i, Struct: tableMap.Struct, Column: -1, Err: err}
+		}
+		if err := tableMap.validate(dialect); err != nil {
+			err.Table = i
+			return err
+		}
+		data.TableMaps = append(data.TableMaps, c.genMapper(*mapper, tableMap.withGoTypes(), dialect))
+	}
+	if err := mapper.validateReferences(); err != nil {
+		return err
 	}
 
+	c.buf.Reset()
 	if err := c.tmpl.Execute(c.buf, data); err != nil {
-		// TODO(paulsmith): return error
-		log.Fatal(err)
+		return fmt.Errorf("executing mapper template: %v", err)
 	}
 
-	// gofmt
+	return gofmt(out, c.buf.Bytes())
+}
+
+// gofmt formats generated Go source and writes it to out.
+func gofmt(out io.Writer, src []byte) error {
 	fset := token.NewFileSet()
-	ast, err := parser.ParseFile(fset, "", c.buf.Bytes(), parser.ParseComments)
+	ast, err := parser.ParseFile(fset, "generated", src, parser.ParseComments)
 	if err != nil {
-		// TODO(paulsmith): return error
-		log.Fatal(err)
+		return fmt.Errorf("parsing generated code: %v", err)
 	}
-	err = format.Node(out, fset, ast)
-	if err != nil {
-		// TODO(paulsmith): return error
-		log.Fatal(err)
+	return format.Node(out, fset, ast)
+}
+
+// dialect determines the SQL dialect for the code generated for a table.
+func (c *Code) dialect(mapper TableMap) (Dialect, error) {
+	if c.Dialect != nil {
+		return c.Dialect, nil
 	}
+	return LookupDialect(mapper.Dialect)
 }
 
-func (c *Code) genMapper(mapper TableMap) tableMapTmpl {
+// rangeFunc reports whether the generated code can use range-over-func
+// iterators, added in Go 1.23.
+func (c *Code) rangeFunc() bool {
+	var major, minor int
+	fmt.Sscanf(strings.TrimPrefix(c.GoVersion, "go"), "%d.%d", &major, &minor)
+	return major > 1 || (major == 1 && minor >= 23)
+}
+
+func (c *Code) genMapper(m Map, mapper TableMap, dialect Dialect) tableMapTmpl {
 	// TODO(paulsmith): move this.
 	mapperFields := []string{
-		"db *sql.DB",
+		"db Querier",
 		"sql map[string]string",
 		"stmt map[string]*sql.Stmt",
 	}
-	return tableMapTmpl{
-		mapper,
-		mapper.Struct + "Mapper",
-		mapperFields,
-		strings.ToLower(mapper.Struct[0:1]),
-		mapper.Struct,
-		mapper.ColumnList(),
-		mapper.Table,
-		mapper.Fields(),
-		mapper.UpdateList(),
-		len(mapper.Columns) + 1,
-		mapper.InsertList(),
+	t := tableMapTmpl{
+		Mapper:         mapper,
+		Dialect:        dialect,
+		MapperType:     mapper.Struct + "Mapper",
+		MapperFields:   mapperFields,
+		VarName:        strings.ToLower(mapper.Struct[0:1]),
+		StructType:     mapper.Struct,
+		ColumnList:     mapper.ColumnList(dialect),
+		Table:          mapper.Table,
+		QuotedTable:    dialect.Quote(mapper.Table),
+		Fields:         mapper.Fields(),
+		UpdateList:     mapper.UpdateList(dialect),
+		UpdateFields:   mapper.UpdateFields(),
+		Version:        mapper.Version,
+		UpdateCount:    len(mapper.Columns) + 1,
+		InsertList:     mapper.InsertList(dialect),
+		SelectSQL:      selectSQL(mapper, dialect),
+		SQL:            statements(mapper, dialect),
+		BatchSize:      mapper.InsertBatchSize(dialect),
+		BatchInsert:    batchInsert(mapper, dialect),
+		KeyArgs:        keyArgs(mapper),
+		ObjKeyArgs:     mapper.keyExprs("%s", "obj"),
+		ObjReturnDests: mapper.returningExprs("&%s", "obj"),
+		BelongsTo:      belongsTo(m, mapper, dialect),
+		HasMany:        hasMany(m, mapper, dialect),
+		Upsert:         upsert(mapper, dialect),
+		DeleteSQL:      mapper.deleteSQL(dialect),
+		Scope:          mapper.scope(dialect),
+		WithDeleted:    withDeleted(mapper, dialect),
+		DeleteKeys:     deleteKeys(mapper, dialect),
+		RangeFunc:      c.rangeFunc(),
+		Page:           page(mapper, dialect),
+	}
+	if c, ok := dialect.(catalog); ok {
+		t.VerifySQL = c.columnsSQL()
+		for _, col := range mapper.Columns {
+			t.VerifyColumns = append(t.VerifyColumns, verifyColumnTmpl{
+				Name:       col.Column,
+				Types:      fmt.Sprintf("%#v", typeNames(col)),
+				Null:       col.Null,
+				PrimaryKey: col.PrimaryKey,
+			})
+		}
+	}
+	for _, rel := range t.BelongsTo {
+		t.SQL["Load"+rel.Name] = rel.SQL
+	}
+	for _, rel := range t.HasMany {
+		t.SQL[rel.Name] = rel.SQL
+	}
+	if t.Upsert != nil {
+		t.SQL["Upsert"] = t.Upsert.SQL
+	}
+	if t.Page != nil {
+		t.SQL["Page"] = t.Page.SQL
+		t.SQL["PageFirst"] = t.Page.FirstSQL
+	}
+	for name, sql := range t.WithDeleted {
+		t.SQL[name+"WithDeleted"] = sql
+	}
+	if t.WithDeleted != nil {
+		t.MapperFields = append(t.MapperFields, "withDeleted bool")
+	}
+	return t
+}
+
+// belongsTo produces the loaders of the structs referenced by the mapper's
+// columns. References to unmapped structs or fields are skipped, having been
+// reported by validateReferences.
+func belongsTo(m Map, mapper TableMap, d Dialect) []belongsToTmpl {
+	var rels []belongsToTmpl
+	for _, col := range mapper.References() {
+		other := m.lookup(col.References.Struct)
+		if other == nil || other.column(col.References.Field) == nil {
+			continue
+		}
+		key := d.Quote(other.column(col.References.Field).Column)
+		rels = append(rels, belongsToTmpl{
+			Name:       col.relation(),
+			Plural:     inflect.Pluralize(col.relation()),
+			Field:      col.Field,
+			Struct:     other.Struct,
+			MapperType: other.Struct + "Mapper",
+			KeyField:   col.References.Field,
+			Table:      other.Table,
+			SQL:        selectSQL(*other, d) + whereSQL(key+" = "+d.Placeholder(1), other.scope(d)),
+			InPrefix:   selectSQL(*other, d) + whereSQL(other.scope(d), key+" IN ("),
+			BatchSize:  d.MaxParams(),
+		})
+	}
+	return rels
+}
+
+// hasMany produces the loaders of the structs whose columns reference the
+// mapper's struct.
+func hasMany(m Map, mapper TableMap, d Dialect) []hasManyTmpl {
+	var rels []hasManyTmpl
+	for _, other := range m {
+		for _, col := range other.References() {
+			if col.References.Struct != mapper.Struct || mapper.column(col.References.Field) == nil {
+				continue
+			}
+			rels = append(rels, hasManyTmpl{
+				Name:       other.inverse(col),
+				KeyField:   col.References.Field,
+				Struct:     other.Struct,
+				MapperType: other.Struct + "Mapper",
+				SQL:        selectSQL(other, d) + whereSQL(d.Quote(col.Column)+" = "+d.Placeholder(1), other.scope(d)),
+			})
+		}
+	}
+	return rels
+}
+
+// keyArgs returns Go expressions for the primary key values of a variable key
+// of the mapper's key type.
+func keyArgs(mapper TableMap) string {
+	if mapper.CompositeKey() {
+		var exprs []string
+		for _, pk := range mapper.PrimaryKeys() {
+			exprs = append(exprs, "key."+pk.FieldName())
+		}
+		return strings.Join(exprs, ", ")
+	}
+	return "key"
+}
+
+// batchInsert produces the parts of a multi-row INSERT statement. The Go
+// expression for a row's value list assumes a function placeholder(n int)
+// string, and the row's 0-based index in a variable row.
+func batchInsert(mapper TableMap, d Dialect) batchInsertTmpl {
+	var (
+		n    = len(mapper.InsertFields())
+		vals []string
+	)
+	for j := 0; j < n; j++ {
+		vals = append(vals, fmt.Sprintf("placeholder(row*%d+%d)", n, j+1))
+	}
+	b := batchInsertTmpl{
+		Prefix: fmt.Sprintf("INSERT INTO %s (%s) VALUES ", d.Quote(mapper.Table), mapper.InsertColumnList(d)),
+		Row:    `"(" + ` + strings.Join(vals, ` + ", " + `) + ` + ")"`,
+	}
+	if mapper.PrimaryKey() != nil && d.Returning() {
+		b.Suffix = " RETURNING " + mapper.returningColumnList(d)
+	}
+	return b
+}
+
+// deleteKeys produces the parts of a DELETE statement for a list of primary
+// keys. The Go expression for a key assumes a function placeholder(n int)
+// string, and the key's 0-based index among the arguments in a variable pos.
+func deleteKeys(mapper TableMap, d Dialect) deleteKeysTmpl {
+	pks := mapper.PrimaryKeys()
+	if len(pks) == 0 {
+		return deleteKeysTmpl{}
+	}
+	k := deleteKeysTmpl{
+		Prefix:    mapper.deleteSQL(d) + whereSQL(mapper.scope(d), d.Quote(pks[0].Column)+" IN ("),
+		Row:       "placeholder(pos+1)",
+		Sep:       ", ",
+		Suffix:    ")",
+		Width:     len(pks),
+		BatchSize: d.MaxParams() / len(pks) * len(pks),
+	}
+	if len(pks) > 1 {
+		// Not every dialect has lists of row values, like
+		// (a, b) IN (($1, $2), ($3, $4)).
+		var conds []string
+		for j, pk := range pks {
+			conds = append(conds, fmt.Sprintf("%q + placeholder(pos+%d)", d.Quote(pk.Column)+" = ", j+1))
+		}
+		k.Prefix = mapper.deleteSQL(d) + " WHERE "
+		k.Row = `"(" + ` + strings.Join(conds, ` + " AND " + `) + ` + ")"`
+		k.Sep, k.Suffix = " OR ", ""
+		if scope := mapper.scope(d); scope != "" {
+			k.Prefix += scope + " AND ("
+			k.Suffix = ")"
+		}
+	}
+	return k
+}
+
+// selectSQL produces a SELECT statement for all the mapped columns of a
+// table, lacking a WHERE clause.
+func selectSQL(mapper TableMap, d Dialect) string {
+	return fmt.Sprintf("SELECT %s FROM %s", mapper.ColumnList(d), d.Quote(mapper.Table))
+}
+
+// statements produces the SQL of the statements a mapper prepares, keyed by
+// name of the method that uses it.
+func statements(mapper TableMap, d Dialect) map[string]string {
+	table := d.Quote(mapper.Table)
+	stmts := map[string]string{
+		"All": selectSQL(mapper, d) + whereSQL(mapper.scope(d)),
+	}
+	for _, col := range mapper.Finders() {
+		stmts[col.FinderName()] = selectSQL(mapper, d) + whereSQL(d.Quote(col.Column)+" = "+d.Placeholder(1), mapper.scope(d))
+	}
+	if mapper.PrimaryKey() == nil {
+		return stmts
+	}
+	stmts["Get"] = selectSQL(mapper, d) + whereSQL(mapper.KeyList(d, 1), mapper.scope(d))
+	n := len(mapper.UpdateFields())
+	conds := []string{mapper.KeyList(d, n+1)}
+	version := mapper.versionColumn()
+	if version != nil {
+		conds = append(conds, fmt.Sprintf("%s = %s", d.Quote(version.Column), d.Placeholder(n+len(mapper.PrimaryKeys())+1)))
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s", table, mapper.UpdateList(d)) + whereSQL(append(conds, mapper.scope(d))...)
+	if version != nil && d.Returning() {
+		update += " RETURNING " + d.Quote(version.Column)
+	}
+	stmts["Update"] = update
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, mapper.InsertColumnList(d), mapper.InsertList(d))
+	if len(mapper.InsertFields()) == 0 && d != MySQL {
+		// Everything is defaulted; only MySQL accepts an empty column list.
+		insert = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", table)
+	}
+	if d.Returning() {
+		insert += " RETURNING " + mapper.returningColumnList(d)
+	}
+	stmts["Insert"] = insert
+	stmts["Delete"] = mapper.deleteSQL(d) + whereSQL(mapper.KeyList(d, 1), mapper.scope(d))
+	if mapper.SoftDelete != "" {
+		stmts["HardDelete"] = fmt.Sprintf("DELETE FROM %s WHERE %s", table, mapper.KeyList(d, 1))
+		deleted := d.Quote(mapper.SoftDelete)
+		stmts["Restore"] = fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s AND %s IS NOT NULL", table, deleted, mapper.KeyList(d, 1), deleted)
 	}
+	return stmts
 }
@@ -1,6 +1,9 @@
 package tablestruct
 
 import (
+	"encoding/json"
+	"fmt"
+	"go/token"
 	"strings"
 
 	"bitbucket.org/pkg/inflect"
@@ -9,11 +12,286 @@ import (
 // ColumnMap describes a mapping between a Go struct field and a database
 // column.
 type ColumnMap struct {
+	// Field is the name of the struct field, or a dotted path to a field of
+	// a nested struct value, like "Audit.CreatedBy" for a field of an
+	// embedded struct.
 	Field      string `json:"field"`
 	Column     string `json:"column"`
 	Type       string `json:"type"`
 	Null       bool   `json:"null"`
 	PrimaryKey bool   `json:"pk"`
+	// GoTypeName is the Go type of the field. If empty, it is derived from
+	// Type and Null. See GoType.
+	GoTypeName string `json:"go_type,omitempty"`
+	// Import is the import path of the package GoTypeName refers to, if it
+	// is not one of the standard packages tablestruct knows.
+	Import string `json:"import,omitempty"`
+	// Finder is which finder methods are generated for the column.
+	Finder Finder `json:"finder,omitempty"`
+	// Default is the SQL expression of the column's default value in the
+	// generated schema, if any.
+	Default string `json:"default,omitempty"`
+	// Unique is whether the generated schema has a UNIQUE constraint on the
+	// column.
+	Unique bool `json:"unique,omitempty"`
+	// References, if set, makes the column a foreign key to a field of
+	// another mapped struct.
+	References *Reference `json:"references,omitempty"`
+	// RenamedFrom is the previous name of the column, for migrations that
+	// rename it. See DiffMaps.
+	RenamedFrom string `json:"renamed_from,omitempty"`
+}
+
+// Finder says which finder methods are generated for a column. In mapping
+// metadata it is "many", "one", false for none, or true for the default.
+type Finder string
+
+const (
+	// FinderDefault is FinderNone for primary key columns, which already
+	// have Get, and for []byte columns, FinderOne for unique columns, and
+	// FinderMany otherwise. The version column of a table has no default
+	// finder either.
+	FinderDefault Finder = ""
+	// FinderNone generates no finders.
+	FinderNone Finder = "none"
+	// FinderOne generates FindOneBy<Field>, which returns the first matching
+	// row.
+	FinderOne Finder = "one"
+	// FinderMany generates FindBy<Field>, which returns all matching rows.
+	FinderMany Finder = "many"
+)
+
+// UnmarshalJSON accepts the finder names, or a boolean for the default set of
+// finders or none at all.
+func (f *Finder) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		if b {
+			*f = FinderDefault
+		} else {
+			*f = FinderNone
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("finder must be a string or boolean, got %s", data)
+	}
+	switch Finder(s) {
+	case FinderDefault, FinderNone, FinderOne, FinderMany:
+		*f = Finder(s)
+		return nil
+	}
+	return fmt.Errorf("unknown finder %q", s)
+}
+
+// MarshalJSON encodes FinderNone as false.
+func (f Finder) MarshalJSON() ([]byte, error) {
+	if f == FinderNone {
+		return []byte("false"), nil
+	}
+	return json.Marshal(string(f))
+}
+
+// finder resolves the default for the column's finder.
+func (c ColumnMap) finder() Finder {
+	if c.Finder != FinderDefault {
+		return c.Finder
+	}
+	if c.PrimaryKey || c.GoType() == "[]byte" {
+		return FinderNone
+	}
+	if c.Unique {
+		return FinderOne
+	}
+	return FinderMany
+}
+
+// FindsOne is whether the column's finder returns a single row rather than a
+// slice.
+func (c ColumnMap) FindsOne() bool {
+	return c.finder() == FinderOne
+}
+
+// FinderName returns the name of the generated finder method for the column,
+// or the empty string if it has none.
+func (c ColumnMap) FinderName() string {
+	switch c.finder() {
+	case FinderOne:
+		return "FindOneBy" + c.FieldName()
+	case FinderMany:
+		return "FindBy" + c.FieldName()
+	}
+	return ""
+}
+
+// FieldName returns the name of the struct field, the last element of the
+// field's path, for naming generated identifiers after it.
+func (c ColumnMap) FieldName() string {
+	return c.Field[strings.LastIndex(c.Field, ".")+1:]
+}
+
+// ParamName returns a name for a Go function parameter holding a value of the
+// column.
+func (c ColumnMap) ParamName() string {
+	name := c.FieldName()
+	n := 0
+	for n < len(name) && 'A' <= name[n] && name[n] <= 'Z' {
+		n++
+	}
+	if n > 1 && n < len(name) {
+		// An initialism leading a longer name, like "URLPath".
+		n--
+	}
+	name = strings.ToLower(name[:n]) + name[n:]
+	switch {
+	case len(name) == 1:
+		// Could collide with the receiver of a generated method.
+		return "value"
+	case token.Lookup(name).IsKeyword(), reservedParams[name]:
+		return "value"
+	}
+	return name
+}
+
+// reservedParams are names used in the bodies of generated methods that a
+// parameter must not shadow.
+var reservedParams = map[string]bool{
+	"ctx":  true,
+	"err":  true,
+	"rows": true,
+	"row":  true,
+	"obj":  true,
+	"objs": true,
+	"args": true,
+}
+
+// GoType returns the Go type of the struct field. Unless set explicitly in
+// the metadata, it is derived from the SQL type of the column and whether it
+// is nullable, and falls back on interface{} for unknown SQL types.
+func (c ColumnMap) GoType() string {
+	return c.goType(false)
+}
+
+// goType is GoType, with nullable columns of derived types either sql.Null*
+// types or, if pointers, pointers like *string.
+func (c ColumnMap) goType(pointers bool) string {
+	if c.GoTypeName != "" {
+		return c.GoTypeName
+	}
+	t, ok := sqlGoTypes[c.baseType()]
+	switch {
+	case !ok:
+		return "interface{}"
+	case c.Null && pointers:
+		return t.pointer()
+	case c.Null:
+		return t.null
+	}
+	return t.notNull
+}
+
+// baseType returns the SQL type of the column in lower case, lacking any
+// length or precision.
+func (c ColumnMap) baseType() string {
+	typ := strings.ToLower(strings.TrimSpace(c.Type))
+	if i := strings.Index(typ, "("); i >= 0 {
+		typ = strings.TrimSpace(typ[:i])
+	}
+	return typ
+}
+
+type goTypes struct {
+	notNull, null string
+}
+
+// pointer returns the pointer type for nullable values in place of a sql.Null*
+// type.
+func (t goTypes) pointer() string {
+	if strings.HasPrefix(t.null, "sql.Null") {
+		return "*" + t.notNull
+	}
+	return t.null
+}
+
+var (
+	intTypes    = goTypes{"int64", "sql.NullInt64"}
+	floatTypes  = goTypes{"float64", "sql.NullFloat64"}
+	boolTypes   = goTypes{"bool", "sql.NullBool"}
+	stringTypes = goTypes{"string", "sql.NullString"}
+	bytesTypes  = goTypes{"[]byte", "[]byte"}
+	timeTypes   = goTypes{"time.Time", "*time.Time"}
+)
+
+// sqlGoTypes maps SQL types, lacking any length or precision, to Go types.
+var sqlGoTypes = map[string]goTypes{
+	"smallint":                    intTypes,
+	"int":                         intTypes,
+	"integer":                     intTypes,
+	"bigint":                      intTypes,
+	"int2":                        intTypes,
+	"int4":                        intTypes,
+	"int8":                        intTypes,
+	"smallserial":                 intTypes,
+	"serial":                      intTypes,
+	"bigserial":                   intTypes,
+	"real":                        floatTypes,
+	"float":                       floatTypes,
+	"float4":                      floatTypes,
+	"float8":                      floatTypes,
+	"double":                      floatTypes,
+	"double precision":            floatTypes,
+	"numeric":                     floatTypes,
+	"decimal":                     floatTypes,
+	"bool":                        boolTypes,
+	"boolean":                     boolTypes,
+	"char":                        stringTypes,
+	"character":                   stringTypes,
+	"varchar":                     stringTypes,
+	"character varying":           stringTypes,
+	"text":                        stringTypes,
+	"uuid":                        stringTypes,
+	"bytea":                       bytesTypes,
+	"blob":                        bytesTypes,
+	"date":                        timeTypes,
+	"datetime":                    timeTypes,
+	"timestamp":                   timeTypes,
+	"timestamptz":                 timeTypes,
+	"timestamp with time zone":    timeTypes,
+	"timestamp without time zone": timeTypes,
+}
+
+// goImport returns the import path of the package the Go type of the column
+// refers to, if any.
+func (c ColumnMap) goImport() string {
+	if c.Import != "" {
+		return c.Import
+	}
+	return goTypeImport(c.GoType())
+}
+
+// goTypeImport returns the import path of the package a Go type refers to, if
+// it is one tablestruct knows.
+func goTypeImport(typ string) string {
+	return stdPackages[goTypePackage(typ)]
+}
+
+// goTypePackage returns the name of the package a Go type refers to, if any.
+func goTypePackage(typ string) string {
+	typ = strings.TrimLeft(typ, "*[]")
+	i := strings.Index(typ, ".")
+	if i < 0 {
+		return ""
+	}
+	return typ[:i]
+}
+
+var stdPackages = map[string]string{
+	"sql":  "database/sql",
+	"time": "time",
+	"json": "encoding/json",
+	"big":  "math/big",
+	"net":  "net",
 }
 
 // FieldToColumn converts a Go struct field name to a database table column
@@ -25,3 +303,36 @@ func FieldToColumn(field string) string {
 	}
 	return inflect.Underscore(field)
 }
+
+// ColumnToField converts a database column name to a Go struct field name. It
+// is mainly snake_case -> CamelCase, with common initialisms in upper case, so
+// that "user_id" becomes "UserID".
+func ColumnToField(column string) string {
+	var field string
+	for _, word := range strings.FieldsFunc(column, func(r rune) bool { return r == '_' || r == ' ' || r == '-' }) {
+		if initialisms[strings.ToLower(word)] {
+			field += strings.ToUpper(word)
+			continue
+		}
+		field += strings.ToUpper(word[:1]) + word[1:]
+	}
+	if !isIdent(field) {
+		field = "X" + field
+	}
+	return field
+}
+
+// initialisms are words that are all upper case in Go names.
+var initialisms = map[string]bool{
+	"api":  true,
+	"html": true,
+	"http": true,
+	"id":   true,
+	"ip":   true,
+	"json": true,
+	"sql":  true,
+	"uri":  true,
+	"url":  true,
+	"uuid": true,
+	"xml":  true,
+}
@@ -0,0 +1,114 @@
+package tablestruct
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dialect describes the differences in SQL syntax between database systems
+// that affect the code tablestruct generates.
+type Dialect interface {
+	// Name is how the dialect is referred to in metadata and on the command
+	// line.
+	Name() string
+	// Placeholder returns the bind parameter for the nth (1-based) argument of
+	// a statement.
+	Placeholder(n int) string
+	// Quote quotes a table or column name.
+	Quote(ident string) string
+	// PlaceholderExpr returns a Go expression that evaluates to the bind
+	// parameter for the argument whose 1-based index is the value of the Go
+	// expression n. It is for statements whose number of arguments is only
+	// known at run time.
+	PlaceholderExpr(n string) string
+	// Returning is whether INSERT statements can hand back the primary key
+	// with a RETURNING clause. If not, generated code uses
+	// sql.Result.LastInsertId instead.
+	Returning() bool
+	// MaxParams is the maximum number of bind parameters in a statement.
+	MaxParams() int
+	// NoLimit is the argument of a LIMIT clause that means no limit, for
+	// queries that have an OFFSET but no LIMIT.
+	NoLimit() string
+}
+
+type postgres struct{}
+
+func (postgres) Name() string             { return "postgres" }
+func (postgres) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+func (postgres) Quote(ident string) string {
+	return `"` + strings.Replace(ident, `"`, `""`, -1) + `"`
+}
+func (postgres) PlaceholderExpr(n string) string {
+	return fmt.Sprintf("fmt.Sprintf(\"$%%d\", %s)", n)
+}
+func (postgres) Returning() bool { return true }
+func (postgres) MaxParams() int  { return 65535 }
+func (postgres) NoLimit() string { return "ALL" }
+
+type mysql struct{}
+
+func (mysql) Name() string             { return "mysql" }
+func (mysql) Placeholder(n int) string { return "?" }
+func (mysql) Quote(ident string) string {
+	return "`" + strings.Replace(ident, "`", "``", -1) + "`"
+}
+func (mysql) PlaceholderExpr(n string) string { return `"?"` }
+func (mysql) Returning() bool                 { return false }
+func (mysql) MaxParams() int                  { return 65535 }
+func (mysql) NoLimit() string                 { return "18446744073709551615" }
+
+type sqlite struct{}
+
+func (sqlite) Name() string             { return "sqlite" }
+func (sqlite) Placeholder(n int) string { return "?" }
+func (sqlite) Quote(ident string) string {
+	return `"` + strings.Replace(ident, `"`, `""`, -1) + `"`
+}
+func (sqlite) PlaceholderExpr(n string) string { return `"?"` }
+func (sqlite) Returning() bool                 { return false }
+
+// MaxParams is SQLITE_MAX_VARIABLE_NUMBER as compiled into SQLite before
+// version 3.32.0.
+func (sqlite) MaxParams() int { return 999 }
+
+func (sqlite) NoLimit() string { return "-1" }
+
+var (
+	// Postgres is the dialect of PostgreSQL. It is the default.
+	Postgres Dialect = postgres{}
+	// MySQL is the dialect of MySQL and MariaDB.
+	MySQL Dialect = mysql{}
+	// SQLite is the dialect of SQLite 3.
+	SQLite Dialect = sqlite{}
+)
+
+var dialects = map[string]Dialect{
+	Postgres.Name(): Postgres,
+	MySQL.Name():    MySQL,
+	SQLite.Name():   SQLite,
+}
+
+// LookupDialect returns the dialect known by name. The empty string names the
+// default dialect, Postgres.
+func LookupDialect(name string) (Dialect, error) {
+	if name == "" {
+		return Postgres, nil
+	}
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %q (have %s)", name, strings.Join(DialectNames(), ", "))
+	}
+	return d, nil
+}
+
+// DialectNames returns the names of all the known dialects, sorted.
+func DialectNames() []string {
+	var names []string
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
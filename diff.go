@@ -0,0 +1,300 @@
+package tablestruct
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Step is a statement of a migration.
+type Step struct {
+	SQL string
+	// Destructive is whether the statement may lose data, like dropping a
+	// column or narrowing its type.
+	Destructive bool
+}
+
+// Migration has the statements that change a database schema to match the
+// mapping metadata, Up, and that change it back, Down.
+type Migration struct {
+	Up   []Step
+	Down []Step
+}
+
+// add adds a step and its inverse to the migration.
+func (m *Migration) add(up, down Step) {
+	m.Up = append(m.Up, up)
+	m.Down = append([]Step{down}, m.Down...)
+}
+
+// Empty is whether the schema already matches the mapping metadata.
+func (m *Migration) Empty() bool {
+	return len(m.Up) == 0
+}
+
+// WriteUp writes the Up statements, each terminated by a semicolon and
+// destructive ones preceded by a comment saying so.
+func (m *Migration) WriteUp(w io.Writer) error {
+	return writeSteps(w, m.Up)
+}
+
+// WriteDown writes the Down statements like WriteUp.
+func (m *Migration) WriteDown(w io.Writer) error {
+	return writeSteps(w, m.Down)
+}
+
+func writeSteps(w io.Writer, steps []Step) error {
+	for _, step := range steps {
+		if step.Destructive {
+			if _, err := io.WriteString(w, "-- DESTRUCTIVE: may lose data\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n\n", step.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationFile matches the names of the files WriteFiles writes.
+var migrationFile = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+
+// WriteFiles writes the migration to a pair of files in dir named
+// NNNN_<name>.up.sql and NNNN_<name>.down.sql, numbered after the highest
+// numbered migration already in dir. It returns the names of the files.
+func (m *Migration) WriteFiles(dir, name string) (up, down string, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	var last int
+	for _, info := range infos {
+		if match := migrationFile.FindStringSubmatch(info.Name()); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > last {
+				last = n
+			}
+		}
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%04d_%s", last+1, name))
+	up, down = prefix+".up.sql", prefix+".down.sql"
+	if err := writeFile(up, m.WriteUp); err != nil {
+		return "", "", err
+	}
+	if err := writeFile(down, m.WriteDown); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+// writeFile creates a new file and writes to it with write.
+func writeFile(name string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Diff compares the tables of the mapping metadata with the tables in the
+// database, read from its catalog, and returns the migration between them.
+// See DiffMaps.
+func Diff(db *sql.DB, d Dialect, want Map) (*Migration, error) {
+	in, ok := d.(Introspector)
+	if !ok {
+		return nil, fmt.Errorf("dialect %s cannot introspect a database", d.Name())
+	}
+	tables, err := in.Tables(db)
+	if err != nil {
+		return nil, err
+	}
+	var have []string
+	for _, t := range want {
+		if contains(tables, t.Table) {
+			have = append(have, t.Table)
+		}
+	}
+	var haveMap Map
+	if len(have) > 0 {
+		if haveMap, err = Introspect(db, d, have...); err != nil {
+			return nil, err
+		}
+	}
+	return DiffMaps(haveMap, want, d)
+}
+
+// DiffMaps returns the migration that changes the schema of the tables in have
+// to that of the tables in want, in dialect d. Tables in want and not have are
+// created, and tables only in have are left alone. Columns are matched by
+// name, or by their "renamed_from" name, and added, dropped, renamed, or have
+// their type or nullability changed. Types are compared without their length
+// or precision. Primary keys, defaults, constraints and indexes of existing
+// tables are not compared.
+func DiffMaps(have, want Map, d Dialect) (*Migration, error) {
+	if err := want.validateReferences(); err != nil {
+		return nil, err
+	}
+	var m Migration
+	for i, t := range want {
+		if err := t.validateSchema(d); err != nil {
+			err.Table = i
+			return nil, err
+		}
+		old := have.lookupTable(t.Table)
+		if old == nil {
+			for _, stmt := range createTable(want, t, d) {
+				m.Up = append(m.Up, Step{SQL: stmt})
+			}
+			m.Down = append([]Step{{SQL: "DROP TABLE " + d.Quote(t.Table), Destructive: true}}, m.Down...)
+			continue
+		}
+		if err := diffTable(&m, want, *old, t, d); err != nil {
+			return nil, fmt.Errorf("table %s: %v", t.Table, err)
+		}
+	}
+	return &m, nil
+}
+
+// diffTable adds the steps that change the columns of the table old to those of
+// the table t.
+func diffTable(m *Migration, want Map, old, t TableMap, d Dialect) error {
+	var (
+		table   = d.Quote(t.Table)
+		matched = make(map[string]bool)
+	)
+	alter := func(format string, args ...interface{}) string {
+		return "ALTER TABLE " + table + " " + fmt.Sprintf(format, args...)
+	}
+	for _, col := range t.Columns {
+		prev := old.columnNamed(col.Column)
+		if prev == nil && col.RenamedFrom != "" {
+			if prev = old.columnNamed(col.RenamedFrom); prev != nil {
+				m.add(
+					Step{SQL: alter("RENAME COLUMN %s TO %s", d.Quote(prev.Column), d.Quote(col.Column))},
+					Step{SQL: alter("RENAME COLUMN %s TO %s", d.Quote(col.Column), d.Quote(prev.Column))},
+				)
+			}
+		}
+		if prev == nil {
+			m.add(
+				Step{SQL: alter("ADD COLUMN %s", columnDef(want, t, col, d))},
+				Step{SQL: alter("DROP COLUMN %s", d.Quote(col.Column)), Destructive: true},
+			)
+			continue
+		}
+		matched[prev.Column] = true
+		if err := diffColumn(m, alter, want, t, col, old, *prev, d); err != nil {
+			return err
+		}
+	}
+	for _, col := range old.Columns {
+		if !matched[col.Column] {
+			m.add(
+				Step{SQL: alter("DROP COLUMN %s", d.Quote(col.Column)), Destructive: true},
+				Step{SQL: alter("ADD COLUMN %s", columnDef(nil, old, col, d))},
+			)
+		}
+	}
+	return nil
+}
+
+// diffColumn adds the steps that change the type and nullability of the
+// column prev of the table old to those of the column col of the table t. The
+// column already has its new name.
+func diffColumn(m *Migration, alter func(string, ...interface{}) string, want Map, t TableMap, col ColumnMap, old TableMap, prev ColumnMap, d Dialect) error {
+	typeChanged := canonicalType(prev) != canonicalType(col)
+	if !typeChanged && prev.Null == col.Null {
+		return nil
+	}
+	name := d.Quote(col.Column)
+	switch d {
+	case Postgres:
+		if typeChanged {
+			m.add(
+				Step{SQL: alter("ALTER COLUMN %s TYPE %s", name, col.Type), Destructive: true},
+				Step{SQL: alter("ALTER COLUMN %s TYPE %s", name, prev.Type), Destructive: true},
+			)
+		}
+		if prev.Null != col.Null {
+			setNull, dropNull := "SET NOT NULL", "DROP NOT NULL"
+			if col.Null {
+				setNull, dropNull = dropNull, setNull
+			}
+			m.add(
+				Step{SQL: alter("ALTER COLUMN %s %s", name, setNull)},
+				Step{SQL: alter("ALTER COLUMN %s %s", name, dropNull)},
+			)
+		}
+	case MySQL:
+		prev.Column = col.Column
+		m.add(
+			Step{SQL: alter("MODIFY COLUMN %s", columnDef(want, t, col, d)), Destructive: typeChanged},
+			Step{SQL: alter("MODIFY COLUMN %s", columnDef(nil, old, prev, d)), Destructive: typeChanged},
+		)
+	default:
+		return fmt.Errorf("%s cannot change the type or nullability of column %s", d.Name(), col.Column)
+	}
+	return nil
+}
+
+// lookupTable returns the table mapping for a table, or nil if it is not
+// mapped.
+func (m Map) lookupTable(table string) *TableMap {
+	for i := range m {
+		if m[i].Table == table {
+			return &m[i]
+		}
+	}
+	return nil
+}
+
+// columnNamed returns the column mapping for a column, or nil if it is not
+// mapped.
+func (t TableMap) columnNamed(column string) *ColumnMap {
+	for i := range t.Columns {
+		if t.Columns[i].Column == column {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// canonicalType returns the SQL type of the column lacking any length or
+// precision, with synonyms replaced by a single name.
+func canonicalType(c ColumnMap) string {
+	typ := c.baseType()
+	if alias, ok := typeAliases[typ]; ok {
+		return alias
+	}
+	return typ
+}
+
+var typeAliases = map[string]string{
+	"int":                         "integer",
+	"int4":                        "integer",
+	"serial":                      "integer",
+	"serial4":                     "integer",
+	"int2":                        "smallint",
+	"smallserial":                 "smallint",
+	"int8":                        "bigint",
+	"bigserial":                   "bigint",
+	"serial8":                     "bigint",
+	"bool":                        "boolean",
+	"character varying":           "varchar",
+	"character":                   "char",
+	"float4":                      "real",
+	"float8":                      "double precision",
+	"double":                      "double precision",
+	"decimal":                     "numeric",
+	"timestamp without time zone": "timestamp",
+	"timestamptz":                 "timestamp with time zone",
+}
@@ -0,0 +1,205 @@
+package tablestruct
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Introspector is implemented by dialects that can read table definitions
+// from the catalog of a database.
+type Introspector interface {
+	// Tables returns the names of the tables in the current schema.
+	Tables(db *sql.DB) ([]string, error)
+	// Columns returns the column mappings of a table, in order, without
+	// field names. It reports whether the database generates primary key
+	// values.
+	Columns(db *sql.DB, table string) ([]ColumnMap, bool, error)
+}
+
+// Introspect reads the catalog of the database to produce initial mapping
+// metadata for the named tables, or for all tables in the current schema if
+// none are named. Struct and field names are derived from table and column
+// names with TableToStruct and ColumnToField.
+func Introspect(db *sql.DB, d Dialect, tables ...string) (Map, error) {
+	in, ok := d.(Introspector)
+	if !ok {
+		return nil, fmt.Errorf("dialect %s cannot introspect a database", d.Name())
+	}
+	if len(tables) == 0 {
+		var err error
+		if tables, err = in.Tables(db); err != nil {
+			return nil, err
+		}
+	}
+	var m Map
+	for _, table := range tables {
+		cols, autoPK, err := in.Columns(db, table)
+		if err != nil {
+			return nil, fmt.Errorf("introspecting table %s: %v", table, err)
+		}
+		if len(cols) == 0 {
+			return nil, fmt.Errorf("introspecting table %s: no such table", table)
+		}
+		for i := range cols {
+			cols[i].Field = ColumnToField(cols[i].Column)
+		}
+		t := TableMap{
+			Struct:  TableToStruct(table),
+			Table:   table,
+			Columns: cols,
+			AutoPK:  autoPK,
+		}
+		if d != Postgres {
+			t.Dialect = d.Name()
+		}
+		m = append(m, t)
+	}
+	return m, nil
+}
+
+// queryStrings runs a query for a single column of strings.
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ss []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	return ss, rows.Err()
+}
+
+// catalog is implemented by dialects that can query the catalog of a database
+// for the columns of a table.
+type catalog interface {
+	// columnsSQL returns a query for the columns of the table named by its
+	// one argument, in order. The result columns are the column name, SQL
+	// type, whether it is nullable, whether it is in the primary key, and
+	// whether the database generates its values.
+	columnsSQL() string
+}
+
+// queryColumns runs the catalog query of a dialect for the columns of a table.
+func queryColumns(db *sql.DB, c catalog, table string) ([]ColumnMap, bool, error) {
+	rows, err := db.Query(c.columnsSQL(), table)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	var (
+		cols   []ColumnMap
+		autoPK bool
+	)
+	for rows.Next() {
+		var (
+			col  ColumnMap
+			auto bool
+		)
+		if err := rows.Scan(&col.Column, &col.Type, &col.Null, &col.PrimaryKey, &auto); err != nil {
+			return nil, false, err
+		}
+		autoPK = autoPK || (auto && col.PrimaryKey)
+		cols = append(cols, col)
+	}
+	return cols, autoPK, rows.Err()
+}
+
+func (postgres) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+}
+
+func (d postgres) Columns(db *sql.DB, table string) ([]ColumnMap, bool, error) {
+	return queryColumns(db, d, table)
+}
+
+func (postgres) columnsSQL() string {
+	return `SELECT c.column_name,
+			CASE WHEN c.data_type IN ('USER-DEFINED', 'ARRAY') THEN c.udt_name
+				WHEN c.character_maximum_length IS NOT NULL
+					THEN c.data_type || '(' || c.character_maximum_length || ')'
+				WHEN c.data_type = 'numeric' AND c.numeric_precision IS NOT NULL
+					THEN c.data_type || '(' || c.numeric_precision || ', ' || c.numeric_scale || ')'
+				ELSE c.data_type END,
+			c.is_nullable = 'YES',
+			EXISTS (SELECT 1 FROM information_schema.table_constraints tc
+				JOIN information_schema.key_column_usage kcu
+					ON kcu.constraint_schema = tc.constraint_schema
+					AND kcu.constraint_name = tc.constraint_name
+				WHERE tc.constraint_type = 'PRIMARY KEY'
+					AND tc.table_schema = c.table_schema AND tc.table_name = c.table_name
+					AND kcu.column_name = c.column_name),
+			c.is_identity = 'YES' OR coalesce(c.column_default, '') LIKE 'nextval(%'
+		FROM information_schema.columns c
+		WHERE c.table_schema = current_schema() AND c.table_name = $1
+		ORDER BY c.ordinal_position`
+}
+
+func (mysql) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+}
+
+func (d mysql) Columns(db *sql.DB, table string) ([]ColumnMap, bool, error) {
+	return queryColumns(db, d, table)
+}
+
+func (mysql) columnsSQL() string {
+	return `SELECT column_name,
+			CASE WHEN character_maximum_length IS NOT NULL OR data_type = 'decimal'
+				THEN column_type ELSE data_type END,
+			is_nullable = 'YES',
+			column_key = 'PRI', extra LIKE '%auto_increment%'
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ?
+		ORDER BY ordinal_position`
+}
+
+func (sqlite) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`)
+}
+
+func (d sqlite) Columns(db *sql.DB, table string) ([]ColumnMap, bool, error) {
+	cols, _, err := queryColumns(db, d, table)
+	if err != nil {
+		return nil, false, err
+	}
+	// A single INTEGER PRIMARY KEY column is an alias for the rowid, which
+	// SQLite assigns automatically.
+	var pks []ColumnMap
+	for _, col := range cols {
+		if col.PrimaryKey {
+			pks = append(pks, col)
+		}
+	}
+	autoPK := len(pks) == 1 && pks[0].Type == "integer"
+	return cols, autoPK, nil
+}
+
+// columnsSQL queries the table_info pragma, which needs SQLite 3.16 or later
+// as a table-valued function. The database's generation of primary keys is
+// left for Columns to work out.
+func (sqlite) columnsSQL() string {
+	return `SELECT name, lower(type), "notnull" = 0 AND pk = 0, pk > 0, 0
+		FROM pragma_table_info(?)
+		ORDER BY cid`
+}
+
+func contains(ss []string, s string) bool {
+	for i := range ss {
+		if ss[i] == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package tablestruct
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 )
 
@@ -19,8 +20,41 @@ func NewMap(in io.Reader) (*Map, error) {
 
 // Imports generates list of import specs required by generated code.
 func (m *Map) Imports() []importSpec {
-	return []importSpec{
+	imports := []importSpec{
+		{"context", ""},
 		{"database/sql", ""},
-		{"log", ""},
+		{"fmt", ""},
 	}
+	seen := make(map[string]bool)
+	for _, spec := range imports {
+		seen[spec.path] = true
+	}
+	for _, t := range *m {
+		for _, spec := range t.typeImports() {
+			if !seen[spec.path] {
+				seen[spec.path] = true
+				imports = append(imports, spec)
+			}
+		}
+	}
+	return imports
+}
+
+// MapError is an error in the mapping metadata. It is located by the index of
+// the table mapping in the Map and, if the error concerns a single column, by
+// the index of the column mapping in the table.
+type MapError struct {
+	Table  int    // index of the table mapping
+	Struct string // struct name of the table mapping
+	Column int    // index of the column mapping, or -1
+	Field  string // field name of the column mapping
+	Err    error
+}
+
+func (e *MapError) Error() string {
+	pos := fmt.Sprintf("table %d (%s)", e.Table, e.Struct)
+	if e.Column >= 0 {
+		pos += fmt.Sprintf(", column %d (%s)", e.Column, e.Field)
+	}
+	return pos + ": " + e.Err.Error()
 }
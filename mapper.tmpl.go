@@ -4,9 +4,367 @@ var supportTemplate = `
 // generated mechanically by tablestruct, do not edit!!
 package {{.Package}}
 
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+)
+
 type Scanner interface {
     Scan(...interface{}) error
 }
+
+type Preparer interface {
+    PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+type Querier interface {
+    Preparer
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type TxBeginner interface {
+    BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+var (
+    _ Querier = (*sql.DB)(nil)
+    _ Querier = (*sql.Tx)(nil)
+    _ Querier = (*sql.Conn)(nil)
+    _ TxBeginner = (*sql.DB)(nil)
+    _ TxBeginner = (*sql.Conn)(nil)
+)
+
+func RunInTx(db TxBeginner, fn func(tx *sql.Tx) error) error {
+    return RunInTxContext(context.Background(), db, nil, fn)
+}
+
+func RunInTxContext(ctx context.Context, db TxBeginner, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+    tx, err := db.BeginTx(ctx, opts)
+    if err != nil {
+        return err
+    }
+    defer func() {
+        if p := recover(); p != nil {
+            tx.Rollback()
+            panic(p)
+        }
+    }()
+    if err := fn(tx); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+var (
+    // ErrNotFound is the error of Get, FindOneBy finders and First when no
+    // row matches, and of Update and Delete when no row has the key.
+    ErrNotFound = errors.New("row not found")
+    // ErrConflict matches, with errors.Is, the error of a mapper method whose
+    // row violates a primary key or unique constraint. The error itself is
+    // that of the database driver.
+    ErrConflict = errors.New("row conflicts with an existing row")
+    // ErrStaleObject is the error of Update for a struct whose version no
+    // longer matches that of its row, which another update has changed or
+    // deleted since the struct was read.
+    ErrStaleObject = errors.New("row has been changed or deleted since it was read")
+)
+
+// MapperError is the error of a mapper method that reads a row by key or
+// writes rows.
+type MapperError struct {
+    Table string
+    Op    string // name of the mapper method, like "Update"
+    SQL   string // of the failed statement
+    Err   error  // ErrNotFound, or the error of the database driver
+}
+
+func (e *MapperError) Error() string {
+    return fmt.Sprintf("%s %s: %v", e.Op, e.Table, e.Err)
+}
+
+func (e *MapperError) Unwrap() error { return e.Err }
+
+func (e *MapperError) Is(target error) bool {
+    return target == ErrConflict && isConflict(e.Err)
+}
+
+// isConflict reports whether an error of a database driver is a violation of
+// a primary key or unique constraint. Drivers have no common error type, so it
+// goes by the SQLSTATE of drivers that report one, and otherwise by message.
+func isConflict(err error) bool {
+    if e, ok := err.(interface{ SQLState() string }); ok {
+        return e.SQLState() == "23505"
+    }
+    msg := err.Error()
+    return strings.Contains(msg, "duplicate key value violates unique constraint") || // lib/pq
+        strings.HasPrefix(msg, "Error 1062") || // go-sql-driver/mysql
+        strings.Contains(msg, "UNIQUE constraint failed") // mattn/go-sqlite3
+}
+
+// mapperError wraps an error of mapper method op on table, reporting
+// sql.ErrNoRows as ErrNotFound. It is nil if err is nil.
+func mapperError(table, op, query string, err error) error {
+    if err == nil {
+        return nil
+    }
+    if err == sql.ErrNoRows {
+        err = ErrNotFound
+    }
+    return &MapperError{Table: table, Op: op, SQL: query, Err: err}
+}
+
+// rowsAffected returns the error of a statement that should have affected a
+// row, ErrNotFound if it affected none.
+func rowsAffected(res sql.Result, err error) error {
+    if err != nil {
+        return err
+    }
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// ErrInvalidCursor is the error of DecodeCursor, and so of the Page methods
+// of mappers, for a cursor that is malformed or of another page key.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor encodes the values of a page key as an opaque cursor that is
+// safe to use in URLs.
+func EncodeCursor(values ...interface{}) (string, error) {
+    b, err := json.Marshal(values)
+    if err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor decodes a cursor encoded by EncodeCursor into the variables
+// dests point to, one for each value of the page key.
+func DecodeCursor(cursor string, dests ...interface{}) error {
+    b, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+    }
+    var values []json.RawMessage
+    if err := json.Unmarshal(b, &values); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+    }
+    if len(values) != len(dests) {
+        return fmt.Errorf("%w: %d values, want %d", ErrInvalidCursor, len(values), len(dests))
+    }
+    for i, v := range values {
+        if err := json.Unmarshal(v, dests[i]); err != nil {
+            return fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+        }
+    }
+    return nil
+}
+
+// SchemaError reports the differences between a table in the database and
+// its mapping, found by the Verify method of its mapper.
+type SchemaError struct {
+    Table    string
+    Problems []string
+}
+
+func (e *SchemaError) Error() string {
+    return fmt.Sprintf("table %s: %s", e.Table, strings.Join(e.Problems, "; "))
+}
+
+// columnSpec is the mapping of a column that Verify checks. types are the
+// names of the SQL type, lacking any length or precision, or empty not to
+// check it.
+type columnSpec struct {
+    name  string
+    types []string
+    null  bool
+    pk    bool
+}
+
+// verifyTable compares the columns of a table in a catalog query with their
+// specs. The query results are the column name, SQL type, whether it is
+// nullable, whether it is in the primary key, and whether the database
+// generates its values.
+func verifyTable(table string, specs []columnSpec, rows *sql.Rows) error {
+    defer rows.Close()
+    type column struct {
+        typ      string
+        null, pk bool
+    }
+    have := make(map[string]column)
+    for rows.Next() {
+        var (
+            name string
+            col  column
+            auto bool
+        )
+        if err := rows.Scan(&name, &col.typ, &col.null, &col.pk, &auto); err != nil {
+            return err
+        }
+        have[name] = col
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    if len(have) == 0 {
+        return &SchemaError{Table: table, Problems: []string{"missing table"}}
+    }
+    var problems []string
+    for _, spec := range specs {
+        col, ok := have[spec.name]
+        if !ok {
+            problems = append(problems, "missing column "+spec.name)
+            continue
+        }
+        typ := strings.ToLower(strings.TrimSpace(col.typ))
+        if i := strings.Index(typ, "("); i >= 0 {
+            typ = strings.TrimSpace(typ[:i])
+        }
+        if len(spec.types) > 0 && !containsString(spec.types, typ) {
+            problems = append(problems, fmt.Sprintf("column %s has type %s, want %s", spec.name, col.typ, spec.types[0]))
+        }
+        switch {
+        case col.null && !spec.null:
+            problems = append(problems, fmt.Sprintf("column %s is nullable, want NOT NULL", spec.name))
+        case !col.null && spec.null:
+            problems = append(problems, fmt.Sprintf("column %s is NOT NULL, want nullable", spec.name))
+        }
+        if spec.pk && !col.pk {
+            problems = append(problems, fmt.Sprintf("column %s is not in the primary key", spec.name))
+        }
+    }
+    if len(problems) > 0 {
+        return &SchemaError{Table: table, Problems: problems}
+    }
+    return nil
+}
+
+func containsString(ss []string, s string) bool {
+    for _, t := range ss {
+        if t == s {
+            return true
+        }
+    }
+    return false
+}
+
+// refKey returns the value of a key as a database driver would receive it,
+// so that a field and a nullable field referencing it have equal keys. It is
+// nil for NULL.
+func refKey(v interface{}) interface{} {
+    key, err := driver.DefaultParameterConverter.ConvertValue(v)
+    if err != nil {
+        return v
+    }
+    if b, ok := key.([]byte); ok {
+        return string(b)
+    }
+    return key
+}
+
+type Column struct {
+    name string
+}
+
+func (c Column) op(op string, v interface{}) Cond {
+    return Cond{parts: []string{c.name + " " + op + " ", ""}, args: []interface{}{v}}
+}
+
+func (c Column) Eq(v interface{}) Cond   { return c.op("=", v) }
+func (c Column) Ne(v interface{}) Cond   { return c.op("<>", v) }
+func (c Column) Lt(v interface{}) Cond   { return c.op("<", v) }
+func (c Column) Le(v interface{}) Cond   { return c.op("<=", v) }
+func (c Column) Gt(v interface{}) Cond   { return c.op(">", v) }
+func (c Column) Ge(v interface{}) Cond   { return c.op(">=", v) }
+func (c Column) Like(v interface{}) Cond { return c.op("LIKE", v) }
+
+func (c Column) In(vs ...interface{}) Cond {
+    if len(vs) == 0 {
+        return Cond{parts: []string{"1 = 0"}}
+    }
+    parts := []string{c.name + " IN ("}
+    for i := 1; i < len(vs); i++ {
+        parts = append(parts, ", ")
+    }
+    return Cond{parts: append(parts, ")"), args: vs}
+}
+
+func (c Column) IsNull() Cond    { return Cond{parts: []string{c.name + " IS NULL"}} }
+func (c Column) IsNotNull() Cond { return Cond{parts: []string{c.name + " IS NOT NULL"}} }
+
+func (c Column) Asc() OrderTerm  { return OrderTerm{c.name + " ASC"} }
+func (c Column) Desc() OrderTerm { return OrderTerm{c.name + " DESC"} }
+
+type Cond struct {
+    // The SQL of the condition is parts interleaved with placeholders for
+    // args, so len(parts) == len(args)+1.
+    parts []string
+    args  []interface{}
+}
+
+func joinConds(sep string, conds []Cond) Cond {
+    c := Cond{parts: []string{"("}}
+    for i, cond := range conds {
+        if i > 0 {
+            c.parts[len(c.parts)-1] += ") " + sep + " ("
+        }
+        c.parts[len(c.parts)-1] += cond.parts[0]
+        c.parts = append(c.parts, cond.parts[1:]...)
+        c.args = append(c.args, cond.args...)
+    }
+    c.parts[len(c.parts)-1] += ")"
+    return c
+}
+
+func And(conds ...Cond) Cond {
+    if len(conds) == 0 {
+        return Cond{parts: []string{"1 = 1"}}
+    }
+    return joinConds("AND", conds)
+}
+
+func Or(conds ...Cond) Cond {
+    if len(conds) == 0 {
+        return Cond{parts: []string{"1 = 0"}}
+    }
+    return joinConds("OR", conds)
+}
+
+func Not(cond Cond) Cond {
+    c := joinConds("", []Cond{cond})
+    c.parts[0] = "NOT " + c.parts[0]
+    return c
+}
+
+func (c Cond) build(placeholder func(n int) string, args []interface{}) (string, []interface{}) {
+    var sql []byte
+    for i, part := range c.parts {
+        if i > 0 {
+            args = append(args, c.args[i-1])
+            sql = append(sql, placeholder(len(args))...)
+        }
+        sql = append(sql, part...)
+    }
+    return string(sql), args
+}
+
+type OrderTerm struct {
+    sql string
+}
 `
 
 var mapperTemplate = `
@@ -19,43 +377,101 @@ import (
 )
 
 {{range .TableMaps}}
+{{$m := .}}
 
 type {{.MapperType}} struct {
     {{range .MapperFields}}{{.}}
     {{end}}
 }
 
-func New{{.MapperType}} (db *sql.DB) *{{.MapperType}} {
+func New{{.MapperType}} (db Querier) (*{{.MapperType}}, error) {
+    return New{{.MapperType}}Context(context.Background(), db)
+}
+
+func New{{.MapperType}}Context(ctx context.Context, db Querier) (*{{.MapperType}}, error) {
     m := &{{.MapperType}}{
         db: db,
         sql: make(map[string]string),
         stmt: make(map[string]*sql.Stmt),
     }
-    m.prepareStatements()
-    return m
+    if err := m.prepareStatements(ctx); err != nil {
+        return nil, err
+    }
+    return m, nil
 }
 
-func ({{.VarName}} {{.MapperType}}) prepareStatements() {
+func ({{.VarName}} {{.MapperType}}) prepareStatements(ctx context.Context) error {
     var rawSql = map[string]string{
-        {{if .Mapper.PrimaryKey}}
-        "Get": "SELECT {{.ColumnList}} FROM {{.Table}} WHERE {{.Mapper.PrimaryKey.Column}} = $1",
-        "Update": "UPDATE {{.Table}} SET {{.Mapper.UpdateList}} WHERE {{.Mapper.PrimaryKey.Column}} = ${{len .Mapper.Columns | add 1}}",
-        "Insert": "INSERT INTO {{.Table}} VALUES ({{.Mapper.InsertList}}) RETURNING {{.Mapper.PrimaryKey.Column}}",
-        "Delete": "DELETE FROM {{.Table}} WHERE {{.Mapper.PrimaryKey.Column}} = $1",
+        {{range $name, $sql := .SQL}}"{{$name}}": {{printf "%q" $sql}},
         {{end}}
-        "All": "SELECT {{.ColumnList}} FROM {{.Table}}",
     }
-    for k, v := range rawSql {
-        stmt, err := {{.VarName}}.db.Prepare(v)
+    for name, query := range rawSql {
+        stmt, err := {{.VarName}}.db.PrepareContext(ctx, query)
         if err != nil {
-            // TODO(paulsmith): return error instead.
-            log.Printf("SQL: %q", v)
-            log.Fatalf("preparing %s SQL: %v", k, err)
+            {{.VarName}}.Close()
+            return fmt.Errorf("{{.MapperType}}: preparing %s SQL %q: %v", name, query, err)
+        }
+        {{.VarName}}.stmt[name] = stmt
+        {{.VarName}}.sql[name] = query
+    }
+    return nil
+}
+
+func ({{.VarName}} {{.MapperType}}) Close() error {
+    {{- if .WithDeleted}}
+    if {{.VarName}}.withDeleted {
+        // The statements are those of the mapper WithDeleted was called on.
+        return nil
+    }
+    {{- end}}
+    var err error
+    for name, stmt := range {{.VarName}}.stmt {
+        if cerr := stmt.Close(); cerr != nil && err == nil {
+            err = cerr
         }
-        {{.VarName}}.stmt[k] = stmt
-        {{.VarName}}.sql[k] = v
+        delete({{.VarName}}.stmt, name)
+    }
+    return err
+}
+
+func ({{.VarName}} {{.MapperType}}) WithTx(tx *sql.Tx) *{{.MapperType}} {
+    return {{.VarName}}.WithTxContext(context.Background(), tx)
+}
+
+func ({{.VarName}} {{.MapperType}}) WithTxContext(ctx context.Context, tx *sql.Tx) *{{.MapperType}} {
+    bound := &{{.MapperType}}{
+        db: tx,
+        sql: {{.VarName}}.sql,
+        stmt: make(map[string]*sql.Stmt, len({{.VarName}}.stmt)),
+        {{if .WithDeleted}}withDeleted: {{.VarName}}.withDeleted,{{end}}
+    }
+    for name, stmt := range {{.VarName}}.stmt {
+        bound.stmt[name] = tx.StmtContext(ctx, stmt)
+    }
+    return bound
+}
+{{if .WithDeleted}}
+// WithDeleted returns a mapper like {{.VarName}} whose reads include the rows
+// that are soft-deleted. It shares the prepared statements of {{.VarName}}, so
+// its Close does nothing.
+func ({{.VarName}} {{.MapperType}}) WithDeleted() *{{.MapperType}} {
+    unscoped := &{{.MapperType}}{
+        db: {{.VarName}}.db,
+        sql: make(map[string]string, len({{.VarName}}.sql)),
+        stmt: make(map[string]*sql.Stmt, len({{.VarName}}.stmt)),
+        withDeleted: true,
+    }
+    for name, stmt := range {{.VarName}}.stmt {
+        unscoped.sql[name] = {{.VarName}}.sql[name]
+        unscoped.stmt[name] = stmt
     }
+    for _, name := range []string{ {{range $name, $sql := .WithDeleted}}"{{$name}}", {{end}} } {
+        unscoped.sql[name] = {{.VarName}}.sql[name+"WithDeleted"]
+        unscoped.stmt[name] = {{.VarName}}.stmt[name+"WithDeleted"]
+    }
+    return unscoped
 }
+{{end}}
 
 func ({{.VarName}} {{.MapperType}}) loadObj(scanner Scanner) (obj *{{.StructType}}, err error) {
     obj = new({{.StructType}})
@@ -67,53 +483,349 @@ func ({{.VarName}} {{.MapperType}}) loadObj(scanner Scanner) (obj *{{.StructType
     return
 }
 
-func ({{.VarName}} {{.MapperType}}) Get(key int64) (*{{.StructType}}, error) {
-    row := {{.VarName}}.stmt["Get"].QueryRow(key)
-    return {{.VarName}}.loadObj(row)
+{{if .Mapper.PrimaryKey}}
+{{if .Mapper.CompositeKey}}
+type {{.Mapper.KeyType}} struct {
+    {{range .Mapper.PrimaryKeys}}{{.FieldName}} {{.GoType}}
+    {{end}}
+}
+
+func ({{.VarName}} {{.MapperType}}) Key(obj *{{.StructType}}) {{.Mapper.KeyType}} {
+    return {{.Mapper.KeyType}}{ {{.ObjKeyArgs}} }
+}
+{{end}}
+
+func ({{.VarName}} {{.MapperType}}) Get(key {{.Mapper.KeyType}}) (*{{.StructType}}, error) {
+    return {{.VarName}}.GetContext(context.Background(), key)
+}
+
+func ({{.VarName}} {{.MapperType}}) GetContext(ctx context.Context, key {{.Mapper.KeyType}}) (*{{.StructType}}, error) {
+    row := {{.VarName}}.stmt["Get"].QueryRowContext(ctx, {{.KeyArgs}})
+    obj, err := {{.VarName}}.loadObj(row)
+    if err != nil {
+        return nil, mapperError({{printf "%q" .Table}}, "Get", {{.VarName}}.sql["Get"], err)
+    }
+    return obj, nil
 }
 
-{{if .Mapper.PrimaryKey}}
 func ({{.VarName}} {{.MapperType}}) Update(obj *{{.StructType}}) error {
+    return {{.VarName}}.UpdateContext(context.Background(), obj)
+}
+
+func ({{.VarName}} {{.MapperType}}) UpdateContext(ctx context.Context, obj *{{.StructType}}) error {
+    err := {{.VarName}}.update(ctx, obj, {{.VarName}}.stmt["Update"])
+    return mapperError({{printf "%q" .Table}}, "Update", {{.VarName}}.sql["Update"], err)
+}
+
+func ({{.VarName}} {{.MapperType}}) update(ctx context.Context, obj *{{.StructType}}, stmt *sql.Stmt) error {
     args := []interface{}{
-        {{range .Fields}}obj.{{.}},
+        {{range .UpdateFields}}obj.{{.}},
         {{end}}
-        obj.{{.Mapper.PrimaryKey.Field}},
+        {{.ObjKeyArgs}},
+        {{with .Version}}obj.{{.}},{{end}}
+    }
+    {{if not .Version}}
+    return rowsAffected(stmt.ExecContext(ctx, args...))
+    {{else if .Dialect.Returning}}
+    err := stmt.QueryRowContext(ctx, args...).Scan(&obj.{{.Version}})
+    if err == sql.ErrNoRows {
+        return ErrStaleObject
+    }
+    return err
+    {{else}}
+    err := rowsAffected(stmt.ExecContext(ctx, args...))
+    switch err {
+    case nil:
+        obj.{{.Version}}++
+    case ErrNotFound:
+        err = ErrStaleObject
     }
-    _, err := {{.VarName}}.stmt["Update"].Exec(args...)
     return err
+    {{end}}
+}
+
+func ({{.VarName}} {{.MapperType}}) UpdateMany(objs []*{{.StructType}}) error {
+    return {{.VarName}}.UpdateManyContext(context.Background(), objs)
+}
+
+func ({{.VarName}} {{.MapperType}}) UpdateManyContext(ctx context.Context, objs []*{{.StructType}}) error {
+    {{- if .Version}}
+    // The updates run on copies of the structs, which only give them their new
+    // versions once all succeed, so a rollback leaves them as they were.
+    copies := make([]*{{.StructType}}, len(objs))
+    for i, obj := range objs {
+        c := *obj
+        copies[i] = &c
+    }
+    if err := {{.VarName}}.updateManyTx(ctx, copies); err != nil {
+        return err
+    }
+    for i, obj := range objs {
+        obj.{{.Version}} = copies[i].{{.Version}}
+    }
+    return nil
 }
 
-func ({{.VarName}} {{.MapperType}}) insert(obj *{{.StructType}}, stmt *sql.Stmt) error {
+func ({{.VarName}} {{.MapperType}}) updateManyTx(ctx context.Context, objs []*{{.StructType}}) error {
+    {{- end}}
+    if _, ok := {{.VarName}}.db.(*sql.Tx); ok {
+        return {{.VarName}}.updateMany(ctx, {{.VarName}}.stmt["Update"], objs)
+    }
+    db, ok := {{.VarName}}.db.(TxBeginner)
+    if !ok {
+        return fmt.Errorf("{{.MapperType}}: cannot begin transaction on %T", {{.VarName}}.db)
+    }
+    return RunInTxContext(ctx, db, nil, func(tx *sql.Tx) error {
+        return {{.VarName}}.updateMany(ctx, tx.StmtContext(ctx, {{.VarName}}.stmt["Update"]), objs)
+    })
+}
+
+func ({{.VarName}} {{.MapperType}}) updateMany(ctx context.Context, stmt *sql.Stmt, objs []*{{.StructType}}) error {
+    for _, obj := range objs {
+        if err := {{.VarName}}.update(ctx, obj, stmt); err != nil {
+            return mapperError({{printf "%q" .Table}}, "UpdateMany", {{.VarName}}.sql["Update"], err)
+        }
+    }
+    return nil
+}
+
+func ({{.VarName}} {{.MapperType}}) insert(ctx context.Context, obj *{{.StructType}}, stmt *sql.Stmt) error {
     args := []interface{}{
         {{range .Mapper.InsertFields}}obj.{{.}},
         {{end}}
     }
-    row := stmt.QueryRow(args...)
-    err := row.Scan(&obj.{{.Mapper.PrimaryKey.Field}})
+    {{if .Dialect.Returning}}
+    row := stmt.QueryRowContext(ctx, args...)
+    err := row.Scan({{.ObjReturnDests}})
     return err
+    {{else if .Mapper.AutoPK}}
+    res, err := stmt.ExecContext(ctx, args...)
+    if err != nil {
+        return err
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        return err
+    }
+    {{with .Mapper.PrimaryKey}}{{if eq .GoType "interface{}"}}obj.{{.Field}} = id{{else}}obj.{{.Field}} = {{.GoType}}(id){{end}}{{end}}
+    return nil
+    {{else}}
+    _, err := stmt.ExecContext(ctx, args...)
+    return err
+    {{end}}
 }
 
 func ({{.VarName}} {{.MapperType}}) Insert(obj *{{.StructType}}) error {
-    return {{.VarName}}.insert(obj, {{.VarName}}.stmt["Insert"])
+    return {{.VarName}}.InsertContext(context.Background(), obj)
+}
+
+func ({{.VarName}} {{.MapperType}}) InsertContext(ctx context.Context, obj *{{.StructType}}) error {
+    err := {{.VarName}}.insert(ctx, obj, {{.VarName}}.stmt["Insert"])
+    return mapperError({{printf "%q" .Table}}, "Insert", {{.VarName}}.sql["Insert"], err)
 }
 
 func ({{.VarName}} {{.MapperType}}) InsertMany(objs []*{{.StructType}}) error {
-    tx, err := {{.VarName}}.db.Begin()
-    if err != nil {
+    return {{.VarName}}.InsertManyContext(context.Background(), objs)
+}
+
+func ({{.VarName}} {{.MapperType}}) InsertManyContext(ctx context.Context, objs []*{{.StructType}}) error {
+    {{- if .Mapper.AutoPK}}
+    // The inserts run on copies of the structs, which only give them their
+    // generated keys once all succeed, so a rollback leaves them as they were.
+    copies := make([]*{{.StructType}}, len(objs))
+    for i, obj := range objs {
+        c := *obj
+        copies[i] = &c
+    }
+    if err := {{.VarName}}.insertManyTx(ctx, copies); err != nil {
+        return err
+    }
+    for i, obj := range objs {
+        {{range .Mapper.PrimaryKeys}}obj.{{.Field}} = copies[i].{{.Field}}
+        {{end}}
+    }
+    return nil
+}
+
+func ({{.VarName}} {{.MapperType}}) insertManyTx(ctx context.Context, objs []*{{.StructType}}) error {
+    {{- end}}
+    if tx, ok := {{.VarName}}.db.(*sql.Tx); ok {
+        return {{.VarName}}.insertMany(ctx, tx, {{.VarName}}.stmt["Insert"], objs)
+    }
+    db, ok := {{.VarName}}.db.(TxBeginner)
+    if !ok {
+        return fmt.Errorf("{{.MapperType}}: cannot begin transaction on %T", {{.VarName}}.db)
+    }
+    return RunInTxContext(ctx, db, nil, func(tx *sql.Tx) error {
+        return {{.VarName}}.insertMany(ctx, tx, tx.StmtContext(ctx, {{.VarName}}.stmt["Insert"]), objs)
+    })
+}
+
+{{with .Upsert}}
+func ({{$m.VarName}} {{$m.MapperType}}) Upsert(obj *{{$m.StructType}}) error {
+    return {{$m.VarName}}.UpsertContext(context.Background(), obj)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) UpsertContext(ctx context.Context, obj *{{$m.StructType}}) error {
+    err := {{$m.VarName}}.upsert(ctx, {{$m.VarName}}.db, obj, {{$m.VarName}}.stmt["Upsert"])
+    return mapperError({{printf "%q" $m.Table}}, "Upsert", {{$m.VarName}}.sql["Upsert"], err)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) upsert(ctx context.Context, q Querier, obj *{{$m.StructType}}, stmt *sql.Stmt) error {
+    args := []interface{}{
+        {{range $m.Mapper.InsertFields}}obj.{{.}},
+        {{end}}
+    }
+    {{if $m.Dialect.Returning}}
+    return stmt.QueryRowContext(ctx, args...).Scan({{$m.ObjReturnDests}})
+    {{else if or $m.Mapper.AutoPK $m.Version}}
+    if _, err := stmt.ExecContext(ctx, args...); err != nil {
+        return err
+    }
+    return q.QueryRowContext(ctx, {{printf "%q" .KeySQL}}, {{.KeyArgs}}).Scan({{$m.ObjReturnDests}})
+    {{else}}
+    _, err := stmt.ExecContext(ctx, args...)
+    return err
+    {{end}}
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) UpsertMany(objs []*{{$m.StructType}}) error {
+    return {{$m.VarName}}.UpsertManyContext(context.Background(), objs)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) UpsertManyContext(ctx context.Context, objs []*{{$m.StructType}}) error {
+    {{- if or $m.Mapper.AutoPK $m.Version}}
+    // The upserts run on copies of the structs, which only give them their
+    // keys and versions once all succeed, so a rollback leaves them as they
+    // were.
+    copies := make([]*{{$m.StructType}}, len(objs))
+    for i, obj := range objs {
+        c := *obj
+        copies[i] = &c
+    }
+    if err := {{$m.VarName}}.upsertManyTx(ctx, copies); err != nil {
         return err
     }
-    stmt := tx.Stmt({{.VarName}}.stmt["Insert"])
+    for i, obj := range objs {
+        {{range $m.Mapper.PrimaryKeys}}obj.{{.Field}} = copies[i].{{.Field}}
+        {{end}}
+        {{with $m.Version}}obj.{{.}} = copies[i].{{.}}{{end}}
+    }
+    return nil
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) upsertManyTx(ctx context.Context, objs []*{{$m.StructType}}) error {
+    {{- end}}
+    if tx, ok := {{$m.VarName}}.db.(*sql.Tx); ok {
+        return {{$m.VarName}}.upsertMany(ctx, tx, {{$m.VarName}}.stmt["Upsert"], objs)
+    }
+    db, ok := {{$m.VarName}}.db.(TxBeginner)
+    if !ok {
+        return fmt.Errorf("{{$m.MapperType}}: cannot begin transaction on %T", {{$m.VarName}}.db)
+    }
+    return RunInTxContext(ctx, db, nil, func(tx *sql.Tx) error {
+        return {{$m.VarName}}.upsertMany(ctx, tx, tx.StmtContext(ctx, {{$m.VarName}}.stmt["Upsert"]), objs)
+    })
+}
+
+{{if and (gt $m.BatchSize 1) (or $m.Dialect.Returning (not $m.Version))}}
+func ({{$m.VarName}} {{$m.MapperType}}) upsertMany(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, objs []*{{$m.StructType}}) error {
+    for len(objs) > 0 {
+        // A statement can't update a row twice, so a batch ends before an
+        // object with the same conflict key as one already in it.
+        seen := make(map[interface{}]bool)
+        size := 0
+        for ; size < len(objs) && size < {{$m.BatchSize}}; size++ {
+            obj := objs[size]
+            key := [...]interface{}{ {{.RefKeys}} }
+            if seen[key] {
+                break
+            }
+            seen[key] = true
+        }
+        if err := {{$m.VarName}}.insertBatch(ctx, tx, objs[:size], "UpsertMany", {{printf "%q" .Suffix}}); err != nil {
+            return err
+        }
+        objs = objs[size:]
+    }
+    return nil
+}
+{{else}}
+func ({{$m.VarName}} {{$m.MapperType}}) upsertMany(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, objs []*{{$m.StructType}}) error {
     for _, obj := range objs {
-        err := {{.VarName}}.insert(obj, stmt)
-        if err != nil {
+        if err := {{$m.VarName}}.upsert(ctx, tx, obj, stmt); err != nil {
+            return mapperError({{printf "%q" $m.Table}}, "UpsertMany", {{$m.VarName}}.sql["Upsert"], err)
+        }
+    }
+    return nil
+}
+{{end}}
+{{end}}
+
+{{if gt .BatchSize 1}}
+func ({{.VarName}} {{.MapperType}}) insertMany(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, objs []*{{.StructType}}) error {
+    for len(objs) > 0 {
+        size := len(objs)
+        if size > {{.BatchSize}} {
+            size = {{.BatchSize}}
+        }
+        if err := {{.VarName}}.insertBatch(ctx, tx, objs[:size], "InsertMany", {{printf "%q" .BatchInsert.Suffix}}); err != nil {
             return err
         }
+        objs = objs[size:]
     }
-    return tx.Commit()
+    return nil
+}
+
+func ({{.VarName}} {{.MapperType}}) insertBatch(ctx context.Context, tx *sql.Tx, objs []*{{.StructType}}, op, suffix string) error {
+    placeholder := func(n int) string { return {{.Dialect.PlaceholderExpr "n"}} }
+    query := []byte({{printf "%q" .BatchInsert.Prefix}})
+    args := make([]interface{}, 0, len(objs)*{{len .Mapper.InsertFields}})
+    for row, obj := range objs {
+        if row > 0 {
+            query = append(query, ", "...)
+        }
+        query = append(query, {{.BatchInsert.Row}}...)
+        args = append(args, {{range .Mapper.InsertFields}}obj.{{.}}, {{end}})
+    }
+    query = append(query, suffix...)
+    {{if .Dialect.Returning}}
+    rows, err := tx.QueryContext(ctx, string(query), args...)
+    if err != nil {
+        return mapperError({{printf "%q" .Table}}, op, string(query), err)
+    }
+    defer rows.Close()
+    for _, obj := range objs {
+        if !rows.Next() {
+            if err := rows.Err(); err != nil {
+                return mapperError({{printf "%q" .Table}}, op, string(query), err)
+            }
+            return fmt.Errorf("{{.MapperType}}: INSERT returned fewer keys than the %d rows inserted", len(objs))
+        }
+        if err := rows.Scan({{.ObjReturnDests}}); err != nil {
+            return err
+        }
+    }
+    return rows.Close()
+    {{else}}
+    _, err := tx.ExecContext(ctx, string(query), args...)
+    return mapperError({{printf "%q" .Table}}, op, string(query), err)
+    {{end}}
+}
+{{else}}
+func ({{.VarName}} {{.MapperType}}) insertMany(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, objs []*{{.StructType}}) error {
+    for _, obj := range objs {
+        if err := {{.VarName}}.insert(ctx, obj, stmt); err != nil {
+            return mapperError({{printf "%q" .Table}}, "InsertMany", {{.VarName}}.sql["Insert"], err)
+        }
+    }
+    return nil
 }
 {{end}}
+{{end}}
 
 func ({{.VarName}} {{.MapperType}}) loadManyObjs(rows *sql.Rows) ([]*{{.StructType}}, error) {
+    defer rows.Close()
     var objs []*{{.StructType}}
     for rows.Next() {
         obj, err := {{.VarName}}.loadObj(rows)
@@ -128,33 +840,620 @@ func ({{.VarName}} {{.MapperType}}) loadManyObjs(rows *sql.Rows) ([]*{{.StructTy
     return objs, nil
 }
 
-func ({{.VarName}} {{.MapperType}}) FindWhere(where string) ([]*{{.StructType}}, error) {
-    sql := "SELECT {{.ColumnList}} FROM {{.Table}} WHERE " + where
-    rows, err := {{.VarName}}.db.Query(sql)
+func ({{.VarName}} {{.MapperType}}) FindWhere(where string, args ...interface{}) ([]*{{.StructType}}, error) {
+    return {{.VarName}}.FindWhereContext(context.Background(), where, args...)
+}
+
+func ({{.VarName}} {{.MapperType}}) FindWhereContext(ctx context.Context, where string, args ...interface{}) ([]*{{.StructType}}, error) {
+    {{if .Scope}}
+    if !{{.VarName}}.withDeleted {
+        where = "(" + where + {{printf "%q" (print ") AND " .Scope)}}
+    }
+    {{end}}
+    sql := {{printf "%q" .SelectSQL}} + " WHERE " + where
+    rows, err := {{.VarName}}.db.QueryContext(ctx, sql, args...)
     if err != nil {
         return nil, err
     }
     return {{.VarName}}.loadManyObjs(rows)
 }
 
+{{range .Mapper.Finders}}
+{{if .FindsOne}}
+func ({{$m.VarName}} {{$m.MapperType}}) {{.FinderName}}({{.ParamName}} {{.GoType}}) (*{{$m.StructType}}, error) {
+    return {{$m.VarName}}.{{.FinderName}}Context(context.Background(), {{.ParamName}})
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) {{.FinderName}}Context(ctx context.Context, {{.ParamName}} {{.GoType}}) (*{{$m.StructType}}, error) {
+    row := {{$m.VarName}}.stmt["{{.FinderName}}"].QueryRowContext(ctx, {{.ParamName}})
+    obj, err := {{$m.VarName}}.loadObj(row)
+    if err != nil {
+        return nil, mapperError({{printf "%q" $m.Table}}, "{{.FinderName}}", {{$m.VarName}}.sql["{{.FinderName}}"], err)
+    }
+    return obj, nil
+}
+{{else}}
+func ({{$m.VarName}} {{$m.MapperType}}) {{.FinderName}}({{.ParamName}} {{.GoType}}) ([]*{{$m.StructType}}, error) {
+    return {{$m.VarName}}.{{.FinderName}}Context(context.Background(), {{.ParamName}})
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) {{.FinderName}}Context(ctx context.Context, {{.ParamName}} {{.GoType}}) ([]*{{$m.StructType}}, error) {
+    rows, err := {{$m.VarName}}.stmt["{{.FinderName}}"].QueryContext(ctx, {{.ParamName}})
+    if err != nil {
+        return nil, err
+    }
+    return {{$m.VarName}}.loadManyObjs(rows)
+}
+{{end}}
+{{end}}
+
 func ({{.VarName}} {{.MapperType}}) All() ([]*{{.StructType}}, error) {
-    rows, err := {{.VarName}}.stmt["All"].Query()
+    return {{.VarName}}.AllContext(context.Background())
+}
+
+func ({{.VarName}} {{.MapperType}}) AllContext(ctx context.Context) ([]*{{.StructType}}, error) {
+    rows, err := {{.VarName}}.stmt["All"].QueryContext(ctx)
     if err != nil {
         return nil, err
     }
     return {{.VarName}}.loadManyObjs(rows)
 }
 
+// {{.StructType}}Iterator steps through the rows of a query one at a time,
+// loading each into a new {{.StructType}}. It must be closed if it is not
+// run to the end.
+type {{.StructType}}Iterator struct {
+    m    {{.MapperType}}
+    rows *sql.Rows
+    obj  *{{.StructType}}
+    err  error
+}
+
+// Next loads the next row, reporting false when there are no more rows or
+// on error.
+func (it *{{.StructType}}Iterator) Next() bool {
+    if it.err != nil || !it.rows.Next() {
+        return false
+    }
+    it.obj, it.err = it.m.loadObj(it.rows)
+    if it.err != nil {
+        it.rows.Close()
+        return false
+    }
+    return true
+}
+
+// Value returns the row loaded by the last call to Next.
+func (it *{{.StructType}}Iterator) Value() *{{.StructType}} {
+    return it.obj
+}
+
+// Err returns the error, if any, that ended the iteration.
+func (it *{{.StructType}}Iterator) Err() error {
+    if it.err != nil {
+        return it.err
+    }
+    return it.rows.Err()
+}
+
+func (it *{{.StructType}}Iterator) Close() error {
+    return it.rows.Close()
+}
+
+func (it *{{.StructType}}Iterator) each(fn func(*{{.StructType}}) error) error {
+    defer it.Close()
+    for it.Next() {
+        if err := fn(it.Value()); err != nil {
+            return err
+        }
+    }
+    return it.Err()
+}
+{{if .RangeFunc}}
+func (it *{{.StructType}}Iterator) yield(yield func(*{{.StructType}}, error) bool) {
+    defer it.Close()
+    for it.Next() {
+        if !yield(it.Value(), nil) {
+            return
+        }
+    }
+    if err := it.Err(); err != nil {
+        yield(nil, err)
+    }
+}
+{{end}}
+func ({{.VarName}} {{.MapperType}}) Iter() (*{{.StructType}}Iterator, error) {
+    return {{.VarName}}.IterContext(context.Background())
+}
+
+func ({{.VarName}} {{.MapperType}}) IterContext(ctx context.Context) (*{{.StructType}}Iterator, error) {
+    rows, err := {{.VarName}}.stmt["All"].QueryContext(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return &{{.StructType}}Iterator{m: {{.VarName}}, rows: rows}, nil
+}
+
+func ({{.VarName}} {{.MapperType}}) Each(fn func(*{{.StructType}}) error) error {
+    return {{.VarName}}.EachContext(context.Background(), fn)
+}
+
+func ({{.VarName}} {{.MapperType}}) EachContext(ctx context.Context, fn func(*{{.StructType}}) error) error {
+    it, err := {{.VarName}}.IterContext(ctx)
+    if err != nil {
+        return err
+    }
+    return it.each(fn)
+}
+{{if .RangeFunc}}
+func ({{.VarName}} {{.MapperType}}) Seq() iter.Seq2[*{{.StructType}}, error] {
+    return {{.VarName}}.SeqContext(context.Background())
+}
+
+func ({{.VarName}} {{.MapperType}}) SeqContext(ctx context.Context) iter.Seq2[*{{.StructType}}, error] {
+    return func(yield func(*{{.StructType}}, error) bool) {
+        it, err := {{.VarName}}.IterContext(ctx)
+        if err != nil {
+            yield(nil, err)
+            return
+        }
+        it.yield(yield)
+    }
+}
+{{end}}
+{{with .Page}}
+// {{$m.StructType}}Cursor is the position in the rows of {{$m.Table}} following
+// a row, by the page key. The empty cursor is the start of the rows.
+type {{$m.StructType}}Cursor string
+
+// Cursor returns the cursor of the rows following obj.
+func ({{$m.VarName}} {{$m.MapperType}}) Cursor(obj *{{$m.StructType}}) ({{$m.StructType}}Cursor, error) {
+    cursor, err := EncodeCursor({{.Args}})
+    return {{$m.StructType}}Cursor(cursor), err
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) Page(after {{$m.StructType}}Cursor, limit int) ([]*{{$m.StructType}}, {{$m.StructType}}Cursor, error) {
+    return {{$m.VarName}}.PageContext(context.Background(), after, limit)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) PageContext(ctx context.Context, after {{$m.StructType}}Cursor, limit int) ([]*{{$m.StructType}}, {{$m.StructType}}Cursor, error) {
+    if limit <= 0 {
+        return nil, "", fmt.Errorf("{{$m.MapperType}}: page limit %d is not positive", limit)
+    }
+    var (
+        rows *sql.Rows
+        err  error
+    )
+    if after == "" {
+        rows, err = {{$m.VarName}}.stmt["PageFirst"].QueryContext(ctx, limit)
+    } else {
+        var key {{$m.StructType}}
+        if err := DecodeCursor(string(after), {{.Dests}}); err != nil {
+            return nil, "", err
+        }
+        rows, err = {{$m.VarName}}.stmt["Page"].QueryContext(ctx, {{.KeyArgs}}, limit)
+    }
+    if err != nil {
+        return nil, "", err
+    }
+    objs, err := {{$m.VarName}}.loadManyObjs(rows)
+    if err != nil || len(objs) < limit {
+        return objs, "", err
+    }
+    next, err := {{$m.VarName}}.Cursor(objs[len(objs)-1])
+    return objs, next, err
+}
+{{end}}
+{{range .BelongsTo}}
+func ({{$m.VarName}} {{$m.MapperType}}) Load{{.Name}}(obj *{{$m.StructType}}) (*{{.Struct}}, error) {
+    return {{$m.VarName}}.Load{{.Name}}Context(context.Background(), obj)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) Load{{.Name}}Context(ctx context.Context, obj *{{$m.StructType}}) (*{{.Struct}}, error) {
+    row := {{$m.VarName}}.stmt["Load{{.Name}}"].QueryRowContext(ctx, obj.{{.Field}})
+    other, err := {{.MapperType}}{}.loadObj(row)
+    if err != nil {
+        return nil, mapperError({{printf "%q" .Table}}, "Load{{.Name}}", {{$m.VarName}}.sql["Load{{.Name}}"], err)
+    }
+    return other, nil
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) Load{{.Plural}}(objs []*{{$m.StructType}}) ([]*{{.Struct}}, error) {
+    return {{$m.VarName}}.Load{{.Plural}}Context(context.Background(), objs)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) Load{{.Plural}}Context(ctx context.Context, objs []*{{$m.StructType}}) ([]*{{.Struct}}, error) {
+    var (
+        seen = make(map[interface{}]bool)
+        keys []interface{}
+    )
+    for _, obj := range objs {
+        key := refKey(obj.{{.Field}})
+        if key == nil || seen[key] {
+            continue
+        }
+        seen[key] = true
+        keys = append(keys, obj.{{.Field}})
+    }
+    placeholder := func(n int) string { return {{$m.Dialect.PlaceholderExpr "n"}} }
+    related := make(map[interface{}]*{{.Struct}}, len(keys))
+    for len(keys) > 0 {
+        size := len(keys)
+        if size > {{.BatchSize}} {
+            size = {{.BatchSize}}
+        }
+        query := []byte({{printf "%q" .InPrefix}})
+        for pos := range keys[:size] {
+            if pos > 0 {
+                query = append(query, ", "...)
+            }
+            query = append(query, placeholder(pos+1)...)
+        }
+        query = append(query, ')')
+        rows, err := {{$m.VarName}}.db.QueryContext(ctx, string(query), keys[:size]...)
+        if err != nil {
+            return nil, mapperError({{printf "%q" .Table}}, "Load{{.Plural}}", string(query), err)
+        }
+        found, err := {{.MapperType}}{}.loadManyObjs(rows)
+        if err != nil {
+            return nil, mapperError({{printf "%q" .Table}}, "Load{{.Plural}}", string(query), err)
+        }
+        for _, other := range found {
+            related[refKey(other.{{.KeyField}})] = other
+        }
+        keys = keys[size:]
+    }
+    loaded := make([]*{{.Struct}}, len(objs))
+    for pos, obj := range objs {
+        loaded[pos] = related[refKey(obj.{{.Field}})]
+    }
+    return loaded, nil
+}
+{{end}}
+
+{{range .HasMany}}
+func ({{$m.VarName}} {{$m.MapperType}}) {{.Name}}(obj *{{$m.StructType}}) ([]*{{.Struct}}, error) {
+    return {{$m.VarName}}.{{.Name}}Context(context.Background(), obj)
+}
+
+func ({{$m.VarName}} {{$m.MapperType}}) {{.Name}}Context(ctx context.Context, obj *{{$m.StructType}}) ([]*{{.Struct}}, error) {
+    rows, err := {{$m.VarName}}.stmt["{{.Name}}"].QueryContext(ctx, obj.{{.KeyField}})
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    return {{.MapperType}}{}.loadManyObjs(rows)
+}
+{{end}}
+
 {{if .Mapper.PrimaryKey}}
 func ({{.VarName}} {{.MapperType}}) Delete(obj *{{.StructType}}) error {
-    _, err := {{.VarName}}.stmt["Delete"].Exec(obj.{{.Mapper.PrimaryKey.Field}})
-    return err
+    return {{.VarName}}.DeleteContext(context.Background(), obj)
+}
+
+func ({{.VarName}} {{.MapperType}}) DeleteContext(ctx context.Context, obj *{{.StructType}}) error {
+    err := rowsAffected({{.VarName}}.stmt["Delete"].ExecContext(ctx, {{.ObjKeyArgs}}))
+    return mapperError({{printf "%q" .Table}}, "Delete", {{.VarName}}.sql["Delete"], err)
+}
+{{if .WithDeleted}}
+func ({{.VarName}} {{.MapperType}}) HardDelete(obj *{{.StructType}}) error {
+    return {{.VarName}}.HardDeleteContext(context.Background(), obj)
+}
+
+func ({{.VarName}} {{.MapperType}}) HardDeleteContext(ctx context.Context, obj *{{.StructType}}) error {
+    err := rowsAffected({{.VarName}}.stmt["HardDelete"].ExecContext(ctx, {{.ObjKeyArgs}}))
+    return mapperError({{printf "%q" .Table}}, "HardDelete", {{.VarName}}.sql["HardDelete"], err)
+}
+
+func ({{.VarName}} {{.MapperType}}) Restore(obj *{{.StructType}}) error {
+    return {{.VarName}}.RestoreContext(context.Background(), obj)
+}
+
+func ({{.VarName}} {{.MapperType}}) RestoreContext(ctx context.Context, obj *{{.StructType}}) error {
+    err := rowsAffected({{.VarName}}.stmt["Restore"].ExecContext(ctx, {{.ObjKeyArgs}}))
+    return mapperError({{printf "%q" .Table}}, "Restore", {{.VarName}}.sql["Restore"], err)
+}
+{{end}}
+func ({{.VarName}} {{.MapperType}}) DeleteMany(objs []*{{.StructType}}) (int64, error) {
+    return {{.VarName}}.DeleteManyContext(context.Background(), objs)
+}
+
+func ({{.VarName}} {{.MapperType}}) DeleteManyContext(ctx context.Context, objs []*{{.StructType}}) (int64, error) {
+    args := make([]interface{}, 0, len(objs)*{{.DeleteKeys.Width}})
+    for _, obj := range objs {
+        args = append(args, {{.ObjKeyArgs}})
+    }
+    return {{.VarName}}.deleteKeys(ctx, "DeleteMany", args)
+}
+
+func ({{.VarName}} {{.MapperType}}) DeleteByKeys(keys []{{.Mapper.KeyType}}) (int64, error) {
+    return {{.VarName}}.DeleteByKeysContext(context.Background(), keys)
+}
+
+func ({{.VarName}} {{.MapperType}}) DeleteByKeysContext(ctx context.Context, keys []{{.Mapper.KeyType}}) (int64, error) {
+    args := make([]interface{}, 0, len(keys)*{{.DeleteKeys.Width}})
+    for _, key := range keys {
+        args = append(args, {{.KeyArgs}})
+    }
+    return {{.VarName}}.deleteKeys(ctx, "DeleteByKeys", args)
+}
+
+// deleteKeys deletes the rows with the keys whose values are args, in
+// statements of as many keys as the database allows bind parameters for, in a
+// transaction.
+func ({{.VarName}} {{.MapperType}}) deleteKeys(ctx context.Context, op string, args []interface{}) (int64, error) {
+    if len(args) == 0 {
+        return 0, nil
+    }
+    if tx, ok := {{.VarName}}.db.(*sql.Tx); ok {
+        return {{.VarName}}.deleteBatches(ctx, tx, op, args)
+    }
+    db, ok := {{.VarName}}.db.(TxBeginner)
+    if !ok {
+        return 0, fmt.Errorf("{{.MapperType}}: cannot begin transaction on %T", {{.VarName}}.db)
+    }
+    var deleted int64
+    err := RunInTxContext(ctx, db, nil, func(tx *sql.Tx) error {
+        var err error
+        deleted, err = {{.VarName}}.deleteBatches(ctx, tx, op, args)
+        return err
+    })
+    return deleted, err
+}
+
+func ({{.VarName}} {{.MapperType}}) deleteBatches(ctx context.Context, tx *sql.Tx, op string, args []interface{}) (int64, error) {
+    placeholder := func(n int) string { return {{.Dialect.PlaceholderExpr "n"}} }
+    var deleted int64
+    for len(args) > 0 {
+        size := len(args)
+        if size > {{.DeleteKeys.BatchSize}} {
+            size = {{.DeleteKeys.BatchSize}}
+        }
+        query := []byte({{printf "%q" .DeleteKeys.Prefix}})
+        for pos := 0; pos < size; pos += {{.DeleteKeys.Width}} {
+            if pos > 0 {
+                query = append(query, {{printf "%q" .DeleteKeys.Sep}}...)
+            }
+            query = append(query, {{.DeleteKeys.Row}}...)
+        }
+        {{with .DeleteKeys.Suffix}}query = append(query, {{printf "%q" .}}...){{end}}
+        res, err := tx.ExecContext(ctx, string(query), args[:size]...)
+        if err != nil {
+            return deleted, mapperError({{printf "%q" .Table}}, op, string(query), err)
+        }
+        n, err := res.RowsAffected()
+        if err != nil {
+            return deleted, err
+        }
+        deleted += n
+        args = args[size:]
+    }
+    return deleted, nil
 }
 {{end}}
 
+func ({{.VarName}} {{.MapperType}}) DeleteWhere(where string, args ...interface{}) (int64, error) {
+    return {{.VarName}}.DeleteWhereContext(context.Background(), where, args...)
+}
+
+func ({{.VarName}} {{.MapperType}}) DeleteWhereContext(ctx context.Context, where string, args ...interface{}) (int64, error) {
+    {{if .Scope}}
+    where = "(" + where + {{printf "%q" (print ") AND " .Scope)}}
+    {{end}}
+    sql := {{printf "%q" .DeleteSQL}} + " WHERE " + where
+    res, err := {{.VarName}}.db.ExecContext(ctx, sql, args...)
+    if err != nil {
+        return 0, mapperError({{printf "%q" .Table}}, "DeleteWhere", sql, err)
+    }
+    return res.RowsAffected()
+}
+
+var {{.StructType}}Columns = struct {
+    {{range .Mapper.Columns}}{{.FieldName}} Column
+    {{end}}
+}{
+    {{range .Mapper.Columns}}{{.FieldName}}: Column{ {{printf "%q" ($m.Dialect.Quote .Column)}} },
+    {{end}}
+}
+
+type {{.StructType}}Query struct {
+    m      {{.MapperType}}
+    where  []Cond
+    order  []OrderTerm
+    limit  int
+    offset int
+}
+
+func ({{.VarName}} {{.MapperType}}) Query() *{{.StructType}}Query {
+    return &{{.StructType}}Query{m: {{.VarName}}, limit: -1}
+}
+
+func (q *{{.StructType}}Query) Where(conds ...Cond) *{{.StructType}}Query {
+    q.where = append(q.where, conds...)
+    return q
+}
+
+func (q *{{.StructType}}Query) OrderBy(terms ...OrderTerm) *{{.StructType}}Query {
+    q.order = append(q.order, terms...)
+    return q
+}
+
+func (q *{{.StructType}}Query) Limit(n int) *{{.StructType}}Query {
+    q.limit = n
+    return q
+}
+
+func (q *{{.StructType}}Query) Offset(n int) *{{.StructType}}Query {
+    q.offset = n
+    return q
+}
+
+func (q *{{.StructType}}Query) build(query string, paged bool) (string, []interface{}) {
+    placeholder := func(n int) string { return {{.Dialect.PlaceholderExpr "n"}} }
+    var args []interface{}
+    conds := q.where
+    {{if .Scope}}
+    if !q.m.withDeleted {
+        conds = append(conds[:len(conds):len(conds)], Cond{parts: []string{ {{printf "%q" .Scope}} }})
+    }
+    {{end}}
+    if len(conds) > 0 {
+        var where string
+        where, args = And(conds...).build(placeholder, args)
+        query += " WHERE " + where
+    }
+    if !paged {
+        return query, args
+    }
+    for i, term := range q.order {
+        if i == 0 {
+            query += " ORDER BY "
+        } else {
+            query += ", "
+        }
+        query += term.sql
+    }
+    if q.limit >= 0 {
+        query += fmt.Sprintf(" LIMIT %d", q.limit)
+    } else if q.offset > 0 {
+        query += " LIMIT {{.Dialect.NoLimit}}"
+    }
+    if q.offset > 0 {
+        query += fmt.Sprintf(" OFFSET %d", q.offset)
+    }
+    return query, args
+}
+
+func (q *{{.StructType}}Query) All() ([]*{{.StructType}}, error) {
+    return q.AllContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) AllContext(ctx context.Context) ([]*{{.StructType}}, error) {
+    query, args := q.build({{printf "%q" .SelectSQL}}, true)
+    rows, err := q.m.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    return q.m.loadManyObjs(rows)
+}
+
+func (q *{{.StructType}}Query) Iter() (*{{.StructType}}Iterator, error) {
+    return q.IterContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) IterContext(ctx context.Context) (*{{.StructType}}Iterator, error) {
+    query, args := q.build({{printf "%q" .SelectSQL}}, true)
+    rows, err := q.m.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    return &{{.StructType}}Iterator{m: q.m, rows: rows}, nil
+}
+
+func (q *{{.StructType}}Query) Each(fn func(*{{.StructType}}) error) error {
+    return q.EachContext(context.Background(), fn)
+}
+
+func (q *{{.StructType}}Query) EachContext(ctx context.Context, fn func(*{{.StructType}}) error) error {
+    it, err := q.IterContext(ctx)
+    if err != nil {
+        return err
+    }
+    return it.each(fn)
+}
+{{if .RangeFunc}}
+func (q *{{.StructType}}Query) Seq() iter.Seq2[*{{.StructType}}, error] {
+    return q.SeqContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) SeqContext(ctx context.Context) iter.Seq2[*{{.StructType}}, error] {
+    return func(yield func(*{{.StructType}}, error) bool) {
+        it, err := q.IterContext(ctx)
+        if err != nil {
+            yield(nil, err)
+            return
+        }
+        it.yield(yield)
+    }
+}
+{{end}}
+func (q *{{.StructType}}Query) First() (*{{.StructType}}, error) {
+    return q.FirstContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) FirstContext(ctx context.Context) (*{{.StructType}}, error) {
+    first := *q
+    first.limit = 1
+    query, args := first.build({{printf "%q" .SelectSQL}}, true)
+    obj, err := q.m.loadObj(q.m.db.QueryRowContext(ctx, query, args...))
+    if err != nil {
+        return nil, mapperError({{printf "%q" .Table}}, "First", query, err)
+    }
+    return obj, nil
+}
+
+func (q *{{.StructType}}Query) Count() (int64, error) {
+    return q.CountContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) CountContext(ctx context.Context) (int64, error) {
+    query, args := q.build({{printf "%q" (print "SELECT COUNT(*) FROM " .QuotedTable)}}, false)
+    var n int64
+    err := q.m.db.QueryRowContext(ctx, query, args...).Scan(&n)
+    return n, err
+}
+
+func (q *{{.StructType}}Query) Exists() (bool, error) {
+    return q.ExistsContext(context.Background())
+}
+
+func (q *{{.StructType}}Query) ExistsContext(ctx context.Context) (bool, error) {
+    query, args := q.build({{printf "%q" (print "SELECT 1 FROM " .QuotedTable)}}, false)
+    var exists bool
+    err := q.m.db.QueryRowContext(ctx, "SELECT EXISTS (" + query + ")", args...).Scan(&exists)
+    return exists, err
+}
+
 func ({{.VarName}} {{.MapperType}}) Table() string {
     return "{{.Table}}"
 }
 
+{{if .VerifySQL}}
+func ({{.VarName}} {{.MapperType}}) Verify() error {
+    return {{.VarName}}.VerifyContext(context.Background())
+}
+
+func ({{.VarName}} {{.MapperType}}) VerifyContext(ctx context.Context) error {
+    rows, err := {{.VarName}}.db.QueryContext(ctx, {{printf "%q" .VerifySQL}}, {{printf "%q" .Table}})
+    if err != nil {
+        return err
+    }
+    return verifyTable({{printf "%q" .Table}}, []columnSpec{
+        {{range .VerifyColumns}}{ {{printf "%q" .Name}}, {{.Types}}, {{.Null}}, {{.PrimaryKey}} },
+        {{end}}
+    }, rows)
+}
+{{end}}
+
+{{end}}
+`
+
+var structsTemplate = `
+// generated mechanically by tablestruct, do not edit!!
+package {{.Package}}
+
+{{if .Imports}}
+import (
+    {{range .Imports}}{{.}}
+    {{end}}
+)
+{{end}}
+
+{{range .Structs}}
+// {{.Name}} is mapped to table {{.Table}}.
+type {{.Name}} struct {
+    {{range .Fields}}{{.Name}} {{.Type}}{{with .Tag}} {{.}}{{end}}
+    {{end}}
+}
 {{end}}
 `
@@ -0,0 +1,84 @@
+package tablestruct
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pageColumns returns the columns of the page key the generated Page method
+// orders rows by, or false if the mapper has no Page method. They are those
+// named by the table's PageBy or, if it is empty, those of the primary key.
+// PageBy is assumed to have been checked by validatePage.
+func (t TableMap) pageColumns() ([]ColumnMap, bool) {
+	if len(t.PageBy) == 0 {
+		pks := t.PrimaryKeys()
+		return pks, len(pks) > 0
+	}
+	var cols []ColumnMap
+	for _, name := range t.PageBy {
+		cols = append(cols, *t.columnNamed(name))
+	}
+	return cols, true
+}
+
+// validatePage checks the page key of the table mapping for errors.
+func (t TableMap) validatePage() *MapError {
+	if len(t.PageBy) == 0 {
+		return nil
+	}
+	pageErr := func(format string, args ...interface{}) *MapError {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("page_by: "+format, args...)}
+	}
+	seen := make(map[string]bool)
+	for _, name := range t.PageBy {
+		col := t.columnNamed(name)
+		switch {
+		case col == nil:
+			return pageErr("column %s is not mapped", name)
+		case col.Null:
+			return pageErr("column %s is nullable", name)
+		case seen[name]:
+			return pageErr("column %s listed more than once", name)
+		}
+		seen[name] = true
+	}
+	pks := t.PrimaryKeys()
+	withKey := len(pks) > 0
+	for _, pk := range pks {
+		withKey = withKey && seen[pk.Column]
+	}
+	if !withKey && !t.uniqueKey(t.PageBy) {
+		return pageErr("columns %s do not include the primary key and are not a unique key", strings.Join(t.PageBy, ", "))
+	}
+	return nil
+}
+
+// page produces the statements of the Page method, or nil if the mapper has
+// none.
+func page(mapper TableMap, d Dialect) *pageTmpl {
+	cols, ok := mapper.pageColumns()
+	if !ok {
+		return nil
+	}
+	var names, params, args, keyArgs, dests []string
+	for i, col := range cols {
+		names = append(names, d.Quote(col.Column))
+		params = append(params, d.Placeholder(i+1))
+		args = append(args, "obj."+col.Field)
+		keyArgs = append(keyArgs, "key."+col.Field)
+		dests = append(dests, "&key."+col.Field)
+	}
+	key, after := names[0], params[0]
+	if len(cols) > 1 {
+		key = "(" + strings.Join(names, ", ") + ")"
+		after = "(" + strings.Join(params, ", ") + ")"
+	}
+	order := strings.Join(names, ", ")
+	return &pageTmpl{
+		SQL:      fmt.Sprintf("%s%s ORDER BY %s LIMIT %s", selectSQL(mapper, d), whereSQL(key+" > "+after, mapper.scope(d)), order, d.Placeholder(len(cols)+1)),
+		FirstSQL: fmt.Sprintf("%s%s ORDER BY %s LIMIT %s", selectSQL(mapper, d), whereSQL(mapper.scope(d)), order, d.Placeholder(1)),
+		Args:     strings.Join(args, ", "),
+		KeyArgs:  strings.Join(keyArgs, ", "),
+		Dests:    strings.Join(dests, ", "),
+	}
+}
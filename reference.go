@@ -0,0 +1,115 @@
+package tablestruct
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/pkg/inflect"
+)
+
+// Reference describes a foreign key, a column whose values are those of a
+// field of another mapped struct, usually its primary key.
+type Reference struct {
+	Struct string `json:"struct"`
+	Field  string `json:"field"`
+	// Name is the name of the relationship from the referencing struct, as
+	// in the belongs-to loader Load<Name>. If empty, it is the name of the
+	// referencing field less an ID suffix, like Author for AuthorID, or else
+	// the name of the referenced struct.
+	Name string `json:"name,omitempty"`
+	// Inverse is the name of the has-many loader on the mapper of the
+	// referenced struct. If empty, it is the plural of the name of the
+	// referencing struct, like Posts.
+	Inverse string `json:"inverse,omitempty"`
+}
+
+// relation returns the name of the belongs-to relationship of a column with a
+// reference.
+func (c ColumnMap) relation() string {
+	if c.References.Name != "" {
+		return c.References.Name
+	}
+	if name := strings.TrimSuffix(c.FieldName(), "ID"); name != c.FieldName() && name != "" {
+		return name
+	}
+	return c.References.Struct
+}
+
+// inverse returns the name of the has-many relationship of a referenced struct
+// back to the table mapping t through its column c.
+func (t TableMap) inverse(c ColumnMap) string {
+	if c.References.Inverse != "" {
+		return c.References.Inverse
+	}
+	return inflect.Pluralize(t.Struct)
+}
+
+// References returns the column mappings that have references to other
+// structs.
+func (t TableMap) References() []ColumnMap {
+	var cols []ColumnMap
+	for _, col := range t.Columns {
+		if col.References != nil {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// lookup returns the table mapping for a struct, or nil if it is not mapped.
+func (m Map) lookup(strct string) *TableMap {
+	for i := range m {
+		if m[i].Struct == strct {
+			return &m[i]
+		}
+	}
+	return nil
+}
+
+// column returns the column mapping for a field, or nil if it is not mapped.
+func (t TableMap) column(field string) *ColumnMap {
+	for i := range t.Columns {
+		if t.Columns[i].Field == field {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// validateReferences checks that references in the table mappings are to
+// mapped fields, and that the loaders generated for them have distinct names.
+func (m Map) validateReferences() *MapError {
+	names := make(map[string]map[string]bool)
+	for _, t := range m {
+		names[t.Struct] = make(map[string]bool)
+	}
+	for i, t := range m {
+		for j, col := range t.Columns {
+			colErr := func(format string, args ...interface{}) *MapError {
+				return &MapError{Table: i, Struct: t.Struct, Column: j, Field: col.Field, Err: fmt.Errorf(format, args...)}
+			}
+			ref := col.References
+			if ref == nil {
+				continue
+			}
+			other := m.lookup(ref.Struct)
+			if other == nil {
+				return colErr("references unmapped struct %s", ref.Struct)
+			}
+			if other.column(ref.Field) == nil {
+				return colErr("references unmapped field %s.%s", ref.Struct, ref.Field)
+			}
+			rel := col.relation()
+			if !isIdent(rel) || names[t.Struct]["Load"+rel] {
+				return colErr("relationship name %q is not a Go identifier or is not unique", rel)
+			}
+			names[t.Struct]["Load"+rel] = true
+			inv := t.inverse(col)
+			if !isIdent(inv) || names[ref.Struct][inv] {
+				return colErr("inverse relationship name %q is not a Go identifier or is not unique", inv)
+			}
+			names[ref.Struct][inv] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package tablestruct
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Schema generates the SQL statements that create the tables of a set of table
+// mappings.
+type Schema struct {
+	// Dialect, if set, is the SQL dialect of the statements for every table,
+	// overriding the dialect in the mapping metadata.
+	Dialect Dialect
+}
+
+// Gen generates a CREATE TABLE statement for each table mapping, followed by
+// CREATE INDEX statements for its indexes. Each statement is terminated by a
+// semicolon. Tables are created in the order of the mappings, so referenced
+// tables must come before the tables that reference them. Errors in the
+// mapping metadata are reported as a *MapError.
+func (s *Schema) Gen(mapper *Map, out io.Writer) error {
+	if err := mapper.validateReferences(); err != nil {
+		return err
+	}
+	for i, tableMap := range *mapper {
+		d := s.Dialect
+		if d == nil {
+			var err error
+			if d, err = LookupDialect(tableMap.Dialect); err != nil {
+				return &MapError{Table: i, Struct: tableMap.Struct, Column: -1, Err: err}
+			}
+		}
+		if err := tableMap.validateSchema(d); err != nil {
+			err.Table = i
+			return err
+		}
+		for _, stmt := range createTable(*mapper, tableMap, d) {
+			if _, err := fmt.Fprintf(out, "%s;\n\n", stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateSchema checks the table mapping for errors that would otherwise show
+// up as broken SQL in dialect d, beyond those that validate checks.
+func (t TableMap) validateSchema(d Dialect) *MapError {
+	if err := t.validate(d); err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for i, col := range t.Columns {
+		if col.Type == "" {
+			return &MapError{Struct: t.Struct, Column: i, Field: col.Field, Err: fmt.Errorf("missing column type")}
+		}
+		columns[col.Column] = true
+	}
+	for i, idx := range t.Indexes {
+		idxErr := func(format string, args ...interface{}) *MapError {
+			return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("index %d: "+format, append([]interface{}{i}, args...)...)}
+		}
+		if len(idx.Columns) == 0 {
+			return idxErr("no columns")
+		}
+		for _, col := range idx.Columns {
+			if !columns[col] {
+				return idxErr("column %s is not mapped", col)
+			}
+		}
+	}
+	return nil
+}
+
+// createTable produces the statements that create the table and its indexes.
+// The tables that columns reference are looked up in m.
+func createTable(m Map, mapper TableMap, d Dialect) []string {
+	var defs []string
+	for _, col := range mapper.Columns {
+		defs = append(defs, columnDef(m, mapper, col, d))
+	}
+	if pks := mapper.keyColumnList(d); pks != "" && !(d == SQLite && autoIncrement(mapper, *mapper.PrimaryKey())) {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", pks))
+	}
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s (\n    %s\n)", d.Quote(mapper.Table), strings.Join(defs, ",\n    ")),
+	}
+	for _, idx := range mapper.Indexes {
+		var (
+			create = "CREATE INDEX"
+			cols   []string
+		)
+		if idx.Unique {
+			create = "CREATE UNIQUE INDEX"
+		}
+		for _, col := range idx.Columns {
+			cols = append(cols, d.Quote(col))
+		}
+		stmts = append(stmts, fmt.Sprintf("%s %s ON %s (%s)", create, d.Quote(idx.name(mapper.Table)), d.Quote(mapper.Table), strings.Join(cols, ", ")))
+	}
+	return stmts
+}
+
+// columnDef produces SQL for the definition of a column in a CREATE TABLE
+// statement.
+func columnDef(m Map, mapper TableMap, col ColumnMap, d Dialect) string {
+	var (
+		typ  = col.Type
+		auto = autoIncrement(mapper, col)
+		def  []string
+	)
+	if auto {
+		// Postgres generates values with a serial pseudo-type and SQLite
+		// with an INTEGER PRIMARY KEY column; MySQL has AUTO_INCREMENT below.
+		switch d {
+		case Postgres:
+			typ = serialType(col.baseType())
+		case SQLite:
+			typ = "integer PRIMARY KEY AUTOINCREMENT"
+		}
+	}
+	def = append(def, d.Quote(col.Column), typ)
+	if !col.Null {
+		def = append(def, "NOT NULL")
+	}
+	if col.Default != "" {
+		def = append(def, "DEFAULT "+col.Default)
+	}
+	if auto && d == MySQL {
+		def = append(def, "AUTO_INCREMENT")
+	}
+	if col.Unique {
+		def = append(def, "UNIQUE")
+	}
+	if ref := col.References; ref != nil {
+		other := m.lookup(ref.Struct)
+		def = append(def, fmt.Sprintf("REFERENCES %s (%s)", d.Quote(other.Table), d.Quote(other.column(ref.Field).Column)))
+	}
+	return strings.Join(def, " ")
+}
+
+// autoIncrement is whether the database generates the values of the column
+// from a sequence. Automatically generated primary keys of other types need a
+// default value expression.
+func autoIncrement(mapper TableMap, col ColumnMap) bool {
+	return mapper.AutoPK && col.PrimaryKey && sqlGoTypes[col.baseType()] == intTypes
+}
+
+// serialType returns the Postgres serial pseudo-type for an integer type.
+func serialType(typ string) string {
+	switch typ {
+	case "smallint", "int2", "smallserial":
+		return "smallserial"
+	case "bigint", "int8", "bigserial":
+		return "bigserial"
+	}
+	return "serial"
+}
+
+// name returns the name of the index, or a name made up from the table and
+// column names if it has none.
+func (idx Index) name(table string) string {
+	if idx.Name != "" {
+		return idx.Name
+	}
+	suffix := "idx"
+	if idx.Unique {
+		suffix = "key"
+	}
+	return strings.Join(append(append([]string{table}, idx.Columns...), suffix), "_")
+}
@@ -0,0 +1,93 @@
+package tablestruct
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scope produces the SQL condition that leaves out soft-deleted rows of the
+// table, or "" if it has no soft delete column.
+func (t TableMap) scope(d Dialect) string {
+	if t.SoftDelete == "" {
+		return ""
+	}
+	return d.Quote(t.SoftDelete) + " IS NULL"
+}
+
+// deleteSQL produces the statement that deletes rows of the table, lacking a
+// WHERE clause. With a soft delete column, it sets the column to the current
+// time rather than deleting them.
+func (t TableMap) deleteSQL(d Dialect) string {
+	if t.SoftDelete == "" {
+		return "DELETE FROM " + d.Quote(t.Table)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s = CURRENT_TIMESTAMP", d.Quote(t.Table), d.Quote(t.SoftDelete))
+}
+
+// whereSQL produces a WHERE clause of the conditions that are not empty, or
+// "" if all are.
+func whereSQL(conds ...string) string {
+	var nonEmpty []string
+	for _, cond := range conds {
+		if cond != "" {
+			nonEmpty = append(nonEmpty, cond)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(nonEmpty, " AND ")
+}
+
+// validateSoftDelete checks the soft delete column of the table mapping for
+// errors.
+func (t TableMap) validateSoftDelete() *MapError {
+	if t.SoftDelete == "" {
+		return nil
+	}
+	softErr := func(format string, args ...interface{}) *MapError {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("soft_delete column %s "+format, append([]interface{}{t.SoftDelete}, args...)...)}
+	}
+	if t.PrimaryKey() == nil {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("soft_delete: no primary key column")}
+	}
+	col := t.columnNamed(t.SoftDelete)
+	switch {
+	case col == nil:
+		return softErr("is not mapped")
+	case col.PrimaryKey:
+		return softErr("is in the primary key")
+	case !col.Null:
+		return softErr("is not nullable")
+	case col.Field == t.Version:
+		return softErr("is the version column")
+	case col.Finder == FinderOne || col.Finder == FinderMany:
+		return softErr("cannot have a finder")
+	}
+	return nil
+}
+
+// withDeleted produces variants that include soft-deleted rows of the
+// statements of the mapper that read rows and leave them out, keyed by the
+// name of the statement.
+func withDeleted(mapper TableMap, d Dialect) map[string]string {
+	if mapper.SoftDelete == "" {
+		return nil
+	}
+	unscoped := mapper
+	unscoped.SoftDelete = ""
+	stmts := statements(unscoped, d)
+	names := []string{"All", "Get"}
+	for _, col := range mapper.Finders() {
+		names = append(names, col.FinderName())
+	}
+	if p := page(unscoped, d); p != nil {
+		stmts["Page"], stmts["PageFirst"] = p.SQL, p.FirstSQL
+		names = append(names, "Page", "PageFirst")
+	}
+	sql := make(map[string]string)
+	for _, name := range names {
+		sql[name] = stmts[name]
+	}
+	return sql
+}
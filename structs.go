@@ -0,0 +1,104 @@
+package tablestruct
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Structs generates the Go struct types that tables are mapped to, so that the
+// mapping metadata is the single source of truth for them.
+type Structs struct {
+	// Tags are the keys of struct tags to give every field, such as "json" or
+	// "db". The value of each tag is the column name.
+	Tags []string
+}
+
+type structTmpl struct {
+	Name   string
+	Table  string
+	Fields []structFieldTmpl
+}
+
+type structFieldTmpl struct {
+	Name string
+	Type string
+	Tag  string // including the backquotes, or empty
+}
+
+// Gen generates Go struct type definitions for a set of table mappings. The
+// type of each field is the Go type of its column mapping. Errors in the
+// mapping metadata are reported as a *MapError.
+func (s *Structs) Gen(mapper *Map, pkg string, out io.Writer) error {
+	data := struct {
+		Package string
+		Imports []importSpec
+		Structs []structTmpl
+	}{
+		Package: pkg,
+	}
+
+	seen := make(map[string]bool)
+	for i, tableMap := range *mapper {
+		dialect, err := LookupDialect(tableMap.Dialect)
+		if err != nil {
+			return &MapError{Table: i, Struct: tableMap.Struct, Column: -1, Err: err}
+		}
+		if err := tableMap.validate(dialect); err != nil {
+			err.Table = i
+			return err
+		}
+		for j, col := range tableMap.Columns {
+			if col.FieldName() != col.Field {
+				return &MapError{Table: i, Struct: tableMap.Struct, Column: j, Field: col.Field, Err: fmt.Errorf("cannot generate a field of a nested struct")}
+			}
+		}
+		tableMap = tableMap.withGoTypes()
+		for _, spec := range goTypeImports(tableMap.Columns) {
+			if !seen[spec.path] {
+				seen[spec.path] = true
+				data.Imports = append(data.Imports, spec)
+			}
+		}
+		data.Structs = append(data.Structs, s.genStruct(tableMap))
+	}
+
+	tmpl, err := template.New("structs").Parse(structsTemplate)
+	if err != nil {
+		return fmt.Errorf("parsing structs template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("executing structs template: %v", err)
+	}
+	return gofmt(out, buf.Bytes())
+}
+
+func (s *Structs) genStruct(mapper TableMap) structTmpl {
+	st := structTmpl{
+		Name:  mapper.Struct,
+		Table: mapper.Table,
+	}
+	for _, col := range mapper.Columns {
+		st.Fields = append(st.Fields, structFieldTmpl{
+			Name: col.Field,
+			Type: col.GoType(),
+			Tag:  s.tag(col),
+		})
+	}
+	return st
+}
+
+// tag produces the struct tag of the field for a column.
+func (s *Structs) tag(col ColumnMap) string {
+	var tags []string
+	for _, key := range s.Tags {
+		tags = append(tags, fmt.Sprintf("%s:%q", key, col.Column))
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "`" + strings.Join(tags, " ") + "`"
+}
@@ -1,17 +1,21 @@
 package tablestruct
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"text/template"
 )
 
 // GenSupport generates the support Go code for all tablestruct mappers.
-func GenSupport(w io.Writer, pkg string) {
+func GenSupport(w io.Writer, pkg string) error {
 	tmpl, err := template.New("support").Parse(supportTemplate)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("parsing support template: %v", err)
 	}
-	if err := tmpl.Execute(w, struct{ Package string }{pkg}); err != nil {
-		panic(err)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Package string }{pkg}); err != nil {
+		return fmt.Errorf("executing support template: %v", err)
 	}
+	return gofmt(w, buf.Bytes())
 }
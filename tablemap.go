@@ -3,6 +3,7 @@ package tablestruct
 import (
 	"fmt"
 	"strings"
+	"unicode"
 
 	"bitbucket.org/pkg/inflect"
 )
@@ -16,8 +17,52 @@ type TableMap struct {
 	// values for the primary key column. `false` means the application must
 	// supply them.
 	AutoPK bool `json:"auto_pk"`
+	// Dialect is the name of the SQL dialect of the database the table lives
+	// in, "postgres" if empty. See LookupDialect.
+	Dialect string `json:"dialect,omitempty"`
+	// BatchSize is the number of rows InsertMany inserts per statement. It is
+	// capped by the dialect's limit on bind parameters. Zero means a default
+	// size, and 1 inserts a row at a time.
+	BatchSize int `json:"batch_size,omitempty"`
+	// NullPointers is whether nullable columns whose Go type is derived from
+	// the SQL type map to pointers like *string, nil for NULL, rather than
+	// sql.Null* types like sql.NullString.
+	NullPointers bool `json:"null_pointers,omitempty"`
+	// Indexes are the indexes of the table in the generated schema.
+	Indexes []Index `json:"indexes,omitempty"`
+	// Upsert, if set, configures the generated Upsert method. See Upsert.
+	Upsert *Upsert `json:"upsert,omitempty"`
+	// PageBy are the names of the columns the generated Page method orders
+	// rows by, and so pages through them by. They must identify a row, so
+	// must include the primary key or be a unique key, and not be nullable.
+	// If empty, they are those of the primary key.
+	PageBy []string `json:"page_by,omitempty"`
+	// Version is the name of the field of a column that counts the updates
+	// of a row, for optimistic locking. Update only updates the row if its
+	// version is still that of the struct, incrementing it, and fails with
+	// ErrStaleObject otherwise. The column must be a non-null integer.
+	Version string `json:"version,omitempty"`
+	// SoftDelete is the name of a nullable column that marks rows as
+	// deleted. Delete sets it to the current time rather than deleting the
+	// row, and reads leave out rows where it is not null, unless through
+	// the mapper WithDeleted returns.
+	SoftDelete string `json:"soft_delete,omitempty"`
 }
 
+// Index describes an index on the columns of a table.
+type Index struct {
+	// Name is the name of the index. If empty, it is made up from the names
+	// of the table and columns.
+	Name    string   `json:"name,omitempty"`
+	Columns []string `json:"columns"`
+	// Unique is whether the index is a unique constraint on the columns.
+	Unique bool `json:"unique,omitempty"`
+}
+
+// defaultBatchSize is the number of rows InsertMany inserts per statement
+// unless the mapping metadata says otherwise.
+const defaultBatchSize = 500
+
 type importSpec struct {
 	path string
 	name string
@@ -31,43 +76,106 @@ func (i importSpec) String() string {
 }
 
 // ColumnList produces SQL for the column expressions in a SELECT statement.
-func (t TableMap) ColumnList() string {
+func (t TableMap) ColumnList(d Dialect) string {
 	var cols []string
 	for _, col := range t.Columns {
-		cols = append(cols, col.Column)
+		cols = append(cols, d.Quote(col.Column))
 	}
 	return strings.Join(cols, ", ")
 }
 
 // UpdateList produces SQL for the column-placeholder pairs in a UPDATE
-// statement.
-func (t TableMap) UpdateList() string {
+// statement. A version column is incremented rather than set, and a soft
+// delete column is left alone.
+func (t TableMap) UpdateList(d Dialect) string {
 	var cols []string
-	for i, col := range t.Columns {
-		cols = append(cols, fmt.Sprintf("%s = $%d", col.Column, i+1))
+	for _, col := range t.Columns {
+		if t.updated(col) {
+			cols = append(cols, fmt.Sprintf("%s = %s", d.Quote(col.Column), d.Placeholder(len(cols)+1)))
+		}
+	}
+	if version := t.versionColumn(); version != nil {
+		c := d.Quote(version.Column)
+		cols = append(cols, fmt.Sprintf("%s = %s + 1", c, c))
 	}
 	return strings.Join(cols, ", ")
 }
 
-// InsertList produces SQL for the placeholders in the value expression portion
-// of an INSERT statement.
-func (t TableMap) InsertList() string {
-	var (
-		vals   []string
-		offset = 1
-	)
-	offset = 0
+// UpdateFields returns a list of struct fields to be used as values in an
+// update statement, all but those of version and soft delete columns.
+func (t TableMap) UpdateFields() []string {
+	var fields []string
+	for _, col := range t.Columns {
+		if t.updated(col) {
+			fields = append(fields, col.Field)
+		}
+	}
+	return fields
+}
+
+// updated reports whether an update statement sets the column to the value of
+// its field.
+func (t TableMap) updated(col ColumnMap) bool {
+	return col.Field != t.Version && col.Column != t.SoftDelete
+}
+
+// versionColumn returns the column mapping of the version field, or nil if
+// the table has none.
+func (t TableMap) versionColumn() *ColumnMap {
+	if t.Version == "" {
+		return nil
+	}
+	for i := range t.Columns {
+		if t.Columns[i].Field == t.Version {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// InsertColumnList produces SQL for the column list of an INSERT statement.
+// An automatically generated primary key column is left out, so the database
+// supplies its value.
+func (t TableMap) InsertColumnList(d Dialect) string {
+	var cols []string
 	for i := range t.Columns {
 		if t.AutoPK && t.Columns[i].PrimaryKey {
-			vals = append(vals, "default")
 			continue
 		}
-		offset++
-		vals = append(vals, fmt.Sprintf("$%d", offset))
+		cols = append(cols, d.Quote(t.Columns[i].Column))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// InsertList produces SQL for the placeholders in the value expression portion
+// of an INSERT statement.
+func (t TableMap) InsertList(d Dialect) string {
+	var vals []string
+	for i := range t.InsertFields() {
+		vals = append(vals, d.Placeholder(i+1))
 	}
 	return strings.Join(vals, ", ")
 }
 
+// InsertBatchSize returns the number of rows per statement InsertMany should
+// insert into the table. A size of 1 means InsertMany inserts a row at a time
+// with the same prepared statement as Insert. That is also the case when the
+// dialect cannot return generated primary keys for more than one row.
+func (t TableMap) InsertBatchSize(d Dialect) int {
+	n := t.BatchSize
+	if n <= 0 {
+		n = defaultBatchSize
+	}
+	fields := len(t.InsertFields())
+	if fields == 0 || (t.AutoPK && !d.Returning()) {
+		return 1
+	}
+	if max := d.MaxParams() / fields; n > max {
+		n = max
+	}
+	return n
+}
+
 // InsertFields returns a list of struct fields to be used as values in an
 // insert statement.
 func (t TableMap) InsertFields() []string {
@@ -90,7 +198,61 @@ func (t TableMap) Fields() []string {
 	return f
 }
 
-// PrimaryKey returns the column mapping for the primary key field/column.
+// Finders returns the column mappings that have finder methods. A soft delete
+// column has none, since they would never find a row, and the version column
+// has none unless its finder is set explicitly.
+func (t TableMap) Finders() []ColumnMap {
+	var cols []ColumnMap
+	for _, col := range t.Columns {
+		if col.Field == t.Version && col.Finder == FinderDefault {
+			continue
+		}
+		if col.FinderName() != "" && col.Column != t.SoftDelete {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// typeImports returns the imports of the packages of Go types that appear in
+// the signatures of generated methods.
+func (t TableMap) typeImports() []importSpec {
+	t = t.withGoTypes()
+	return goTypeImports(append(t.Finders(), t.PrimaryKeys()...))
+}
+
+// goTypeImports returns the imports of the packages of the Go types of the
+// columns. Packages are imported by the name the Go type refers to them by.
+func goTypeImports(cols []ColumnMap) []importSpec {
+	var specs []importSpec
+	for _, col := range cols {
+		path := col.goImport()
+		if path == "" {
+			continue
+		}
+		spec := importSpec{path: path}
+		if name := goTypePackage(col.GoType()); name != path[strings.LastIndex(path, "/")+1:] {
+			spec.name = name
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// withGoTypes returns a copy of the table mapping with the Go type of every
+// column set explicitly, as the table's options resolve it.
+func (t TableMap) withGoTypes() TableMap {
+	cols := make([]ColumnMap, len(t.Columns))
+	for i, col := range t.Columns {
+		col.GoTypeName = col.goType(t.NullPointers)
+		cols[i] = col
+	}
+	t.Columns = cols
+	return t
+}
+
+// PrimaryKey returns the column mapping for the primary key field/column. For
+// a composite primary key, it is the first of its columns.
 func (t TableMap) PrimaryKey() *ColumnMap {
 	for i := range t.Columns {
 		if t.Columns[i].PrimaryKey {
@@ -100,6 +262,210 @@ func (t TableMap) PrimaryKey() *ColumnMap {
 	return nil
 }
 
+// PrimaryKeys returns the column mappings for the primary key, more than one if
+// it is a composite key.
+func (t TableMap) PrimaryKeys() []ColumnMap {
+	var pks []ColumnMap
+	for _, col := range t.Columns {
+		if col.PrimaryKey {
+			pks = append(pks, col)
+		}
+	}
+	return pks
+}
+
+// CompositeKey is whether the primary key has more than one column.
+func (t TableMap) CompositeKey() bool {
+	return len(t.PrimaryKeys()) > 1
+}
+
+// KeyType returns the Go type of the primary key. It is the type of the field
+// for a single column key, and the generated struct type <Struct>Key for a
+// composite key.
+func (t TableMap) KeyType() string {
+	if t.CompositeKey() {
+		return t.Struct + "Key"
+	}
+	if pk := t.PrimaryKey(); pk != nil {
+		return pk.GoType()
+	}
+	return ""
+}
+
+// KeyList produces SQL for the condition of a WHERE clause that matches the
+// primary key, numbering placeholders from n.
+func (t TableMap) KeyList(d Dialect, n int) string {
+	var conds []string
+	for i, pk := range t.PrimaryKeys() {
+		conds = append(conds, fmt.Sprintf("%s = %s", d.Quote(pk.Column), d.Placeholder(n+i)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// keyColumnList produces SQL for the list of primary key columns.
+func (t TableMap) keyColumnList(d Dialect) string {
+	var cols []string
+	for _, pk := range t.PrimaryKeys() {
+		cols = append(cols, d.Quote(pk.Column))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// returningColumnList produces SQL for the list of columns returned by inserts
+// and upserts: those of the primary key and the version column, if any.
+func (t TableMap) returningColumnList(d Dialect) string {
+	cols := t.keyColumnList(d)
+	if version := t.versionColumn(); version != nil {
+		cols += ", " + d.Quote(version.Column)
+	}
+	return cols
+}
+
+// keyExprs returns Go expressions for the primary key fields of the struct
+// value, or pointer to it, in the variable named v, each formatted by format.
+func (t TableMap) keyExprs(format, v string) string {
+	var exprs []string
+	for _, pk := range t.PrimaryKeys() {
+		exprs = append(exprs, fmt.Sprintf(format, v+"."+pk.Field))
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// returningExprs is keyExprs for the fields of the columns of
+// returningColumnList.
+func (t TableMap) returningExprs(format, v string) string {
+	exprs := t.keyExprs(format, v)
+	if t.Version != "" {
+		exprs += ", " + fmt.Sprintf(format, v+"."+t.Version)
+	}
+	return exprs
+}
+
+// validate checks the table mapping for errors that would otherwise show up as
+// broken generated code in dialect d. The Table index of the error is left for
+// the caller to fill in.
+func (t TableMap) validate(d Dialect) *MapError {
+	tableErr := func(format string, args ...interface{}) *MapError {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf(format, args...)}
+	}
+	if !isIdent(t.Struct) {
+		return tableErr("struct name %q is not a Go identifier", t.Struct)
+	}
+	if t.Table == "" {
+		return tableErr("missing table name")
+	}
+	if len(t.Columns) == 0 {
+		return tableErr("no columns")
+	}
+	var (
+		fields  = make(map[string]bool)
+		names   = make(map[string]bool)
+		columns = make(map[string]bool)
+	)
+	for i, col := range t.Columns {
+		colErr := func(format string, args ...interface{}) *MapError {
+			return &MapError{Struct: t.Struct, Column: i, Field: col.Field, Err: fmt.Errorf(format, args...)}
+		}
+		switch {
+		case !isFieldPath(col.Field):
+			return colErr("field name %q is not a Go identifier", col.Field)
+		case col.Column == "":
+			return colErr("missing column name")
+		case fields[col.Field]:
+			return colErr("field %s mapped more than once", col.Field)
+		case names[col.FieldName()]:
+			return colErr("field name %s is not unique among the fields of the struct", col.FieldName())
+		case columns[col.Column]:
+			return colErr("column %s mapped more than once", col.Column)
+		}
+		fields[col.Field] = true
+		names[col.FieldName()] = true
+		columns[col.Column] = true
+	}
+	if t.AutoPK && t.PrimaryKey() == nil {
+		return tableErr("auto_pk set but no primary key column")
+	}
+	if t.AutoPK && t.CompositeKey() && !d.Returning() {
+		return tableErr("auto_pk with a composite primary key is not supported by %s", d.Name())
+	}
+	for _, pk := range t.PrimaryKeys() {
+		// A column of unknown type, mapping to interface{}, gets the
+		// benefit of the doubt.
+		typ := pk.GoType()
+		if !t.AutoPK || integerTypes[typ] || typ == "interface{}" {
+			continue
+		}
+		// Without RETURNING, the generated key is that of LastInsertId.
+		switch {
+		case !d.Returning():
+			return tableErr("auto_pk set but primary key column %s has Go type %s, not an integer type, which %s cannot return", pk.Column, typ, d.Name())
+		case pk.Default == "":
+			return tableErr("auto_pk set but primary key column %s has Go type %s, not an integer type, and no default", pk.Column, typ)
+		}
+	}
+	if err := t.validateVersion(); err != nil {
+		return err
+	}
+	if err := t.validateSoftDelete(); err != nil {
+		return err
+	}
+	if err := t.validateUpsert(); err != nil {
+		return err
+	}
+	return t.validatePage()
+}
+
+// validateVersion checks the version field of the table mapping for errors.
+func (t TableMap) validateVersion() *MapError {
+	if t.Version == "" {
+		return nil
+	}
+	versionErr := func(format string, args ...interface{}) *MapError {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("version field %s "+format, append([]interface{}{t.Version}, args...)...)}
+	}
+	col := t.versionColumn()
+	switch {
+	case col == nil:
+		return versionErr("is not mapped")
+	case col.PrimaryKey:
+		return versionErr("is in the primary key")
+	case col.Null:
+		return versionErr("is nullable")
+	case !integerTypes[col.GoType()]:
+		return versionErr("has Go type %s, not an integer type", col.GoType())
+	}
+	return nil
+}
+
+var integerTypes = map[string]bool{
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+}
+
+// isIdent reports whether s is a valid Go identifier.
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isFieldPath reports whether s is a Go identifier, or identifiers separated
+// by dots.
+func isFieldPath(s string) bool {
+	for _, name := range strings.Split(s, ".") {
+		if !isIdent(name) {
+			return false
+		}
+	}
+	return true
+}
+
 // StructToTable converts a Go struct name to a database table name. It is
 // mainly CamelCase -> snake_case, with some special cases, and is overridable.
 func StructToTable(strct string) string {
@@ -108,3 +474,9 @@ func StructToTable(strct string) string {
 	}
 	return inflect.Underscore(strct)
 }
+
+// TableToStruct converts a database table name to a Go struct name. It is the
+// singular of the table name, converted with ColumnToField.
+func TableToStruct(table string) string {
+	return ColumnToField(inflect.Singularize(table))
+}
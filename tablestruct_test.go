@@ -3,44 +3,107 @@ package tablestruct
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"text/template"
 
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type Fataler interface {
 	Fatal(...interface{})
 }
 
-func openTestDB(f Fataler) *sql.DB {
-	dbname := os.Getenv("PGDATABASE")
-	sslmode := os.Getenv("PGSSLMODE")
-	timeout := os.Getenv("PGCONNECT_TIMEOUT")
+// testBackend is a database system the code generation tests run against.
+type testBackend struct {
+	dialect   Dialect
+	driver    string // database/sql driver name
+	driverPkg string // import path of the driver package
+}
+
+var testBackends = map[string]testBackend{
+	"postgres": {Postgres, "postgres", "github.com/lib/pq"},
+	"sqlite":   {SQLite, "sqlite3", "github.com/mattn/go-sqlite3"},
+}
 
-	if dbname == "" {
-		os.Setenv("PGDATABASE", "tablestruct_test")
+// backend returns the test backend named by the TABLESTRUCT_TEST_DIALECT
+// environment variable, PostgreSQL by default.
+func backend(f Fataler) testBackend {
+	name := os.Getenv("TABLESTRUCT_TEST_DIALECT")
+	if name == "" {
+		name = "postgres"
+	}
+	b, ok := testBackends[name]
+	if !ok {
+		f.Fatal("unknown TABLESTRUCT_TEST_DIALECT ", name)
 	}
+	return b
+}
 
-	if sslmode == "" {
-		os.Setenv("PGSSLMODE", "disable")
+// dataSource returns the data source name for the backend. SQLite databases
+// are files in dir.
+func (b testBackend) dataSource(dir string) string {
+	if b.dialect == SQLite {
+		return filepath.Join(dir, "tablestruct_test.db")
 	}
+	return ""
+}
+
+func openTestDB(f Fataler, b testBackend, dsn string) *sql.DB {
+	if b.dialect == Postgres {
+		dbname := os.Getenv("PGDATABASE")
+		sslmode := os.Getenv("PGSSLMODE")
+		timeout := os.Getenv("PGCONNECT_TIMEOUT")
+
+		if dbname == "" {
+			os.Setenv("PGDATABASE", "tablestruct_test")
+		}
+
+		if sslmode == "" {
+			os.Setenv("PGSSLMODE", "disable")
+		}
 
-	if timeout == "" {
-		os.Setenv("PGCONNECT_TIMEOUT", "10")
+		if timeout == "" {
+			os.Setenv("PGCONNECT_TIMEOUT", "10")
+		}
 	}
 
-	db, err := sql.Open("postgres", "")
+	db, err := sql.Open(b.driver, dsn)
 	if err != nil {
 		f.Fatal(err)
 	}
 	return db
 }
 
+// openDBTemplate generates the openDB function the driver code of a test uses
+// to connect to the same database as the test.
+var openDBTemplate = template.Must(template.New("openDB").Parse(`
+package main
+
+import (
+    "database/sql"
+
+    _ "{{.DriverPkg}}"
+)
+
+func openDB() (*sql.DB, error) {
+    return sql.Open({{printf "%q" .Driver}}, {{printf "%q" .DataSource}})
+}
+
+var placeholders = []string{ {{range .Placeholders}}{{printf "%q" .}}, {{end}} }
+
+func placeholder(n int) string {
+    return placeholders[n-1]
+}
+`))
+
 func tempDir(f Fataler) string {
 	name, err := ioutil.TempDir("", "tablestruct_test")
 	if err != nil {
@@ -66,7 +129,7 @@ func tempGoFile(dir string, f Fataler) *os.File {
 var get = CodeGenTest{
 	CreateTableSQL: `CREATE TABLE t (id int, val int)`,
 	CleanupSQL:     `DROP TABLE t`,
-	TableSetupSQL:  `INSERT INTO t (SELECT generate_series(0, 10), generate_series(100, 110))`,
+	TableSetupSQL:  `INSERT INTO t VALUES (0, 100), (1, 101), (2, 102), (3, 103), (4, 104), (5, 105), (6, 106), (7, 107), (8, 108), (9, 109), (10, 110)`,
 	Metadata: `
 [
     {
@@ -89,11 +152,8 @@ var get = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type T struct {
@@ -102,11 +162,14 @@ type T struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewTMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewTMapper(db)
     t, err := m.Get(8)
     if err != nil {
         log.Fatal(err)
@@ -117,10 +180,54 @@ func main() {
 	Expected: "108\n",
 }
 
+var getContext = CodeGenTest{
+	CreateTableSQL: get.CreateTableSQL,
+	CleanupSQL:     get.CleanupSQL,
+	TableSetupSQL:  get.TableSetupSQL,
+	Metadata:       get.Metadata,
+	DriverCode: `
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+)
+
+type T struct {
+    ID    int64
+    Value int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewTMapperContext(context.Background(), db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := m.GetContext(ctx, 8); !errors.Is(err, context.Canceled) {
+        log.Fatalf("want context.Canceled, got %v", err)
+    }
+    t, err := m.GetContext(context.Background(), 8)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d\n", t.Value)
+}
+`,
+	Expected: "108\n",
+}
+
 var all = CodeGenTest{
 	CreateTableSQL: `CREATE TABLE zipcodes (id int, zipcode varchar)`,
 	CleanupSQL:     `DROP TABLE zipcodes`,
-	TableSetupSQL:  `INSERT INTO zipcodes (SELECT generate_series(0, 9), generate_series(21230, 21239)::varchar)`,
+	TableSetupSQL:  `INSERT INTO zipcodes VALUES (0, '21230'), (1, '21231'), (2, '21232'), (3, '21233'), (4, '21234'), (5, '21235'), (6, '21236'), (7, '21237'), (8, '21238'), (9, '21239')`,
 	Metadata: `
 [
     {
@@ -142,11 +249,8 @@ var all = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type ZIPCode struct {
@@ -155,11 +259,14 @@ type ZIPCode struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewZIPCodeMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewZIPCodeMapper(db)
     zips, err := m.All()
     if err != nil {
         log.Fatal(err)
@@ -211,11 +318,8 @@ var insert = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type Person struct {
@@ -225,11 +329,14 @@ type Person struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewPersonMapper(db)
     var before, after int
     if err := db.QueryRow("SELECT COUNT(*) FROM person").Scan(&before); err != nil {
         log.Fatal(err)
@@ -268,11 +375,8 @@ var update = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type Person struct {
@@ -282,11 +386,14 @@ type Person struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewPersonMapper(db)
     p := Person{42, "Brian Eno", 66}
     if err = m.Update(&p); err != nil {
         log.Fatal(err)
@@ -315,11 +422,8 @@ var insertMany = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type Person struct {
@@ -329,11 +433,14 @@ type Person struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewPersonMapper(db)
     var before, after int
     if err := db.QueryRow("SELECT COUNT(*) FROM person").Scan(&before); err != nil {
         log.Fatal(err)
@@ -367,6 +474,221 @@ func main() {
 	Expected: "delta: 3\n",
 }
 
+var insertManyBatch = CodeGenTest{
+	CreateTableSQL:    insertAutoPK.CreateTableSQL,
+	CreateTableSQLFor: insertAutoPK.CreateTableSQLFor,
+	CleanupSQL:        insertAutoPK.CleanupSQL,
+	Metadata:          strings.Replace(insertAutoPK.Metadata, `"auto_pk": true,`, `"auto_pk": true, "batch_size": 2,`, 1),
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    people := []*Person{
+        {Name: "Paul Smith", Age: 37},
+        {Name: "Brian Eno", Age: 66},
+        {Name: "Ada Lovelace", Age: 27},
+        {Name: "Grace Hopper", Age: 85},
+        {Name: "Alan Turing", Age: 41},
+    }
+    if err = m.InsertMany(people); err != nil {
+        log.Fatal(err)
+    }
+    for _, p := range people {
+        q, err := m.Get(p.ID)
+        if err != nil {
+            log.Fatal(err)
+        }
+        fmt.Printf("%d %s\n", p.ID, q.Name)
+    }
+}
+`,
+	Expected: `1 Paul Smith
+2 Brian Eno
+3 Ada Lovelace
+4 Grace Hopper
+5 Alan Turing
+`,
+}
+
+var insertManyRollback = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE person (id int primary key, name varchar, age int)`,
+	CleanupSQL:     insert.CleanupSQL,
+	Metadata:       strings.Replace(insert.Metadata, `"table": "person",`, `"table": "person", "batch_size": 2,`, 1),
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    people := []*Person{
+        {42, "Paul Smith", 37},
+        {43, "Brian Eno", 66},
+        {44, "Ada Lovelace", 27},
+        {42, "Paul Smith", 37},
+    }
+    fmt.Printf("error: %v\n", m.InsertMany(people) != nil)
+    if err := m.Insert(&Person{45, "Grace Hopper", 85}); err != nil {
+        log.Fatal(err)
+    }
+    var n int
+    if err := db.QueryRow("SELECT COUNT(*) FROM person").Scan(&n); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("count: %d\n", n)
+}
+`,
+	Expected: "error: true\ncount: 1\n",
+}
+
+var insertManyAutoRollback = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE person (id serial primary key, name varchar(100) unique, age int)`,
+	CreateTableSQLFor: map[string]string{
+		"sqlite": `CREATE TABLE person (id integer primary key autoincrement, name varchar(100) unique, age int)`,
+	},
+	CleanupSQL: insert.CleanupSQL,
+	Metadata:   insertManyBatch.Metadata,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    people := []*Person{
+        {Name: "Paul Smith", Age: 37},
+        {Name: "Brian Eno", Age: 66},
+        {Name: "Ada Lovelace", Age: 27},
+        {Name: "Paul Smith", Age: 37},
+    }
+    err = m.InsertMany(people)
+    fmt.Println(err != nil, people[0].ID, people[1].ID, people[2].ID)
+    if err := m.InsertMany(people[:3]); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(people[0].ID != 0, people[1].ID != people[0].ID)
+}
+`,
+	Expected: "true 0 0 0\ntrue true\n",
+}
+
+var txTest = CodeGenTest{
+	CreateTableSQL: insert.CreateTableSQL,
+	CleanupSQL:     insert.CleanupSQL,
+	Metadata:       insert.Metadata,
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Age     int
+}
+
+func count(db *sql.DB) int {
+    var n int
+    if err := db.QueryRow("SELECT COUNT(*) FROM person").Scan(&n); err != nil {
+        log.Fatal(err)
+    }
+    return n
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    err = RunInTx(db, func(tx *sql.Tx) error {
+        if err := m.WithTx(tx).Insert(&Person{42, "Paul Smith", 37}); err != nil {
+            return err
+        }
+        return errors.New("roll back")
+    })
+    fmt.Printf("%v, count: %d\n", err, count(db))
+    err = RunInTx(db, func(tx *sql.Tx) error {
+        tm := m.WithTx(tx)
+        p := &Person{42, "Paul Smith", 37}
+        if err := tm.Insert(p); err != nil {
+            return err
+        }
+        p.Name = "Brian Eno"
+        return tm.Update(p)
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+    p, err := m.Get(42)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("count: %d, name: %s\n", count(db), p.Name)
+}
+`,
+	Expected: "roll back, count: 0\ncount: 1, name: Brian Eno\n",
+}
+
 var deleteTest = CodeGenTest{
 	CreateTableSQL: get.CreateTableSQL,
 	CleanupSQL:     get.CleanupSQL,
@@ -376,11 +698,8 @@ var deleteTest = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type T struct {
@@ -389,11 +708,14 @@ type T struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewTMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewTMapper(db)
     var before, after, exists int
     if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&before); err != nil {
         log.Fatal(err)
@@ -427,11 +749,8 @@ var findWhere = CodeGenTest{
 package main
 
 import (
-    "database/sql"
     "fmt"
     "log"
-
-    _ "github.com/lib/pq"
 )
 
 type T struct {
@@ -440,66 +759,1607 @@ type T struct {
 }
 
 func main() {
-    db, err := sql.Open("postgres", "")
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewTMapper(db)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewTMapper(db)
     res, err := m.FindWhere("val > 105")
     if err != nil {
         log.Fatal(err)
     }
     fmt.Printf("%d\n", len(res))
-}
-`,
-	Expected: "5\n",
-}
-
-var table = CodeGenTest{
-	CreateTableSQL: `CREATE TABLE foo (id serial)`,
-	CleanupSQL:     `DROP TABLE foo`,
-	Metadata:       `[{"struct": "Foo", "table": "foo", "columns": [{"field": "ID", "column": "id", "pk": true}]}]`,
-	DriverCode: `
-package main
-
-import (
-    "database/sql"
-    "fmt"
-    "log"
-
-    _ "github.com/lib/pq"
-)
-
-type Foo struct {
-    ID int64
-}
-
-func main() {
-    db, err := sql.Open("postgres", "")
+    res, err = m.FindWhere("val > " + placeholder(1) + " AND id < " + placeholder(2), 102, 5)
     if err != nil {
         log.Fatal(err)
     }
-    m := NewFooMapper(db)
-    fmt.Printf("%s\n", m.Table())
+    fmt.Printf("%d\n", len(res))
 }
 `,
-	Expected: "foo\n",
+	Expected: "5\n2\n",
 }
 
-type CodeGenTest struct {
+var findBy = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE person (id int, name varchar, email varchar, age int)`,
+	CleanupSQL:     insert.CleanupSQL,
+	TableSetupSQL:  `INSERT INTO person VALUES (1, 'Paul Smith', 'paul@example.com', 37), (2, 'Brian Eno', 'brian@example.com', 66), (3, 'Paul Smith', 'paul@example.org', 27)`,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "int",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar"
+        }, {
+            "field": "Email",
+            "column": "email",
+            "type": "varchar",
+            "finder": "one"
+        }, {
+            "field": "Age",
+            "column": "age",
+            "type": "int",
+            "finder": false
+        }]
+    }
+]
+`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Email   string
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    people, err := m.FindByName("Paul Smith")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d\n", len(people))
+    p, err := m.FindOneByEmail("brian@example.com")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d %s\n", p.ID, p.Name)
+}
+`,
+	Expected: "2\n2 Brian Eno\n",
+}
+
+var insertAutoPK = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE person (id serial primary key, name varchar, age int)`,
+	CreateTableSQLFor: map[string]string{
+		"sqlite": `CREATE TABLE person (id integer primary key autoincrement, name varchar, age int)`,
+	},
+	CleanupSQL:    insert.CleanupSQL,
+	TableSetupSQL: `INSERT INTO person (name, age) VALUES ('Brian Eno', 66)`,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "auto_pk": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar"
+        }, {
+            "field": "Age",
+            "column": "age",
+            "type": "int"
+        }]
+    }
+]
+`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    p := Person{Name: "Paul Smith", Age: 37}
+    if err = m.Insert(&p); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d\n", p.ID)
+    var name string
+    if err := db.QueryRow("SELECT name FROM person WHERE id = 2").Scan(&name); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s\n", name)
+}
+`,
+	Expected: "2\nPaul Smith\n",
+}
+
+var prepareError = CodeGenTest{
+	CreateTableSQL: get.CreateTableSQL,
+	CleanupSQL:     get.CleanupSQL,
+	Metadata:       `[{"struct": "T", "table": "t", "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "Value", "column": "value"}]}]`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type T struct {
+    ID    int64
+    Value int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewTMapper(db)
+    fmt.Printf("%v %v\n", m == nil, err != nil)
+}
+`,
+	Expected: "true true\n",
+}
+
+var query = CodeGenTest{
+	CreateTableSQL: findBy.CreateTableSQL,
+	CleanupSQL:     findBy.CleanupSQL,
+	TableSetupSQL:  `INSERT INTO person VALUES (1, 'Paul Smith', 'paul@example.com', 37), (2, 'Brian Eno', 'brian@example.com', 66), (3, 'Ada Lovelace', 'ada@example.com', 27), (4, 'Grace Hopper', 'grace@example.com', 85), (5, 'Alan Turing', NULL, 41)`,
+	Metadata:       findBy.Metadata,
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Email   sql.NullString
+    Age     int
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    c := PersonColumns
+    people, err := m.Query().Where(c.Age.Gt(30)).OrderBy(c.Age.Desc()).Limit(2).Offset(1).All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, p := range people {
+        fmt.Printf("%s\n", p.Name)
+    }
+    people, err = m.Query().Where(Or(c.Name.Like("A%"), c.Email.IsNull()), Not(c.ID.In(3, 4))).OrderBy(c.ID.Asc()).Offset(0).All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d %s\n", len(people), people[0].Name)
+    n, err := m.Query().Where(c.Age.Le(66)).Count()
+    if err != nil {
+        log.Fatal(err)
+    }
+    exists, err := m.Query().Where(c.Name.Eq("Brian Eno")).Exists()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("count: %d, exists: %v\n", n, exists)
+    p, err := m.Query().OrderBy(c.Name.Asc()).Offset(1).First()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("first: %s\n", p.Name)
+}
+`,
+	Expected: `Brian Eno
+Alan Turing
+1 Alan Turing
+count: 4, exists: true
+first: Alan Turing
+`,
+}
+
+var compositeKey = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE membership (tenant_id int, id int, role varchar, PRIMARY KEY (tenant_id, id))`,
+	CleanupSQL:     `DROP TABLE membership`,
+	TableSetupSQL:  `INSERT INTO membership VALUES (1, 1, 'owner'), (1, 2, 'member'), (2, 1, 'owner'), (2, 2, 'member')`,
+	Metadata: `
+[
+    {
+        "struct": "Membership",
+        "table": "membership",
+        "columns": [{
+            "field": "TenantID",
+            "column": "tenant_id",
+            "type": "int",
+            "pk": true
+        }, {
+            "field": "ID",
+            "column": "id",
+            "type": "int",
+            "pk": true
+        }, {
+            "field": "Role",
+            "column": "role",
+            "type": "varchar"
+        }]
+    }
+]
+`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Membership struct {
+    TenantID int64
+    ID       int64
+    Role     string
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewMembershipMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    ms, err := m.Get(MembershipKey{TenantID: 2, ID: 2})
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d %d %s\n", ms.TenantID, ms.ID, ms.Role)
+    ms.Role = "admin"
+    if err := m.Update(ms); err != nil {
+        log.Fatal(err)
+    }
+    if err := m.Delete(&Membership{TenantID: 1, ID: 2}); err != nil {
+        log.Fatal(err)
+    }
+    all, err := m.Query().OrderBy(MembershipColumns.TenantID.Asc(), MembershipColumns.ID.Asc()).All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, ms := range all {
+        fmt.Printf("%v %s\n", m.Key(ms), ms.Role)
+    }
+}
+`,
+	Expected: `2 2 member
+{1 1} owner
+{2 1} owner
+{2 2} admin
+`,
+}
+
+var bulk = CodeGenTest{
+	CleanupSQL:    `DROP TABLE membership; DROP TABLE page`,
+	TableSetupSQL: `INSERT INTO membership VALUES (1, 1, 'owner'), (1, 2, 'member'), (2, 1, 'owner'), (2, 2, 'member'), (3, 1, 'guest'); INSERT INTO page VALUES ('a', 'A'), ('b', 'B'), ('c', 'C')`,
+	Metadata: `
+[
+    {
+        "struct": "Membership",
+        "table": "membership",
+        "columns": [
+            {"field": "TenantID", "column": "tenant_id", "type": "integer", "pk": true},
+            {"field": "ID", "column": "id", "type": "integer", "pk": true},
+            {"field": "Role", "column": "role", "type": "varchar(20)"}
+        ]
+    },
+    {
+        "struct": "Page",
+        "table": "page",
+        "columns": [
+            {"field": "Slug", "column": "slug", "type": "varchar(20)", "pk": true},
+            {"field": "Title", "column": "title", "type": "varchar(20)"}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    memberships, err := NewMembershipMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    pages, err := NewPageMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    owners, err := memberships.FindByRole("owner")
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, ms := range owners {
+        ms.Role = "admin"
+    }
+    if err := memberships.UpdateMany(owners); err != nil {
+        log.Fatal(err)
+    }
+    n, err := memberships.DeleteByKeys([]MembershipKey{{1, 2}, {2, 2}, {9, 9}})
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(n)
+    n, err = memberships.DeleteWhere("role = "+placeholder(1), "guest")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(n)
+    all, err := memberships.All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, ms := range all {
+        fmt.Printf("%v %s\n", memberships.Key(ms), ms.Role)
+    }
+    n, err = pages.DeleteMany([]*Page{{Slug: "a"}, {Slug: "c"}})
+    if err != nil {
+        log.Fatal(err)
+    }
+    count, err := pages.Query().Count()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(n, count)
+}
+`,
+	Expected: "2\n1\n{1 1} admin\n{2 1} admin\n2 1\n",
+}
+
+var errorsTest = CodeGenTest{
+	CleanupSQL:    `DROP TABLE account`,
+	TableSetupSQL: `INSERT INTO account VALUES (1, 'paul@example.com')`,
+	Metadata: `
+[
+    {
+        "struct": "Account",
+        "table": "account",
+        "columns": [
+            {"field": "ID", "column": "id", "type": "integer", "pk": true},
+            {"field": "Email", "column": "email", "type": "varchar(100)", "unique": true}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewAccountMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    _, err = m.Get(2)
+    var merr *MapperError
+    if !errors.As(err, &merr) {
+        log.Fatalf("want *MapperError, got %v", err)
+    }
+    fmt.Println(merr.Op, merr.Table, errors.Is(err, ErrNotFound), errors.Is(err, ErrConflict))
+    _, err = m.FindOneByEmail("brian@example.com")
+    fmt.Println(errors.Is(err, ErrNotFound))
+    err = m.Update(&Account{ID: 2, Email: "brian@example.com"})
+    fmt.Println(err, errors.Is(err, ErrNotFound))
+    err = m.Delete(&Account{ID: 2})
+    fmt.Println(errors.Is(err, ErrNotFound))
+    err = m.Insert(&Account{ID: 1, Email: "brian@example.com"})
+    fmt.Println(errors.Is(err, ErrConflict), errors.Is(err, ErrNotFound))
+    err = m.InsertMany([]*Account{{ID: 2, Email: "brian@example.com"}, {ID: 3, Email: "paul@example.com"}})
+    fmt.Println(errors.Is(err, ErrConflict))
+    fmt.Println(m.Update(&Account{ID: 1, Email: "paul@example.com"}))
+}
+`,
+	Expected: "Get account true false\ntrue\nUpdate account: row not found true\ntrue\ntrue false\ntrue\n<nil>\n",
+}
+
+var iterTest = CodeGenTest{
+	CreateTableSQL: findBy.CreateTableSQL,
+	CleanupSQL:     findBy.CleanupSQL,
+	TableSetupSQL:  query.TableSetupSQL,
+	Metadata:       findBy.Metadata,
+	GoVersion:      "1.23",
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID      int64
+    Name    string
+    Email   sql.NullString
+    Age     int
+}
+
+var errStop = errors.New("stop")
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    n := 0
+    err = m.Each(func(p *Person) error {
+        n++
+        if p.ID == 2 {
+            return errStop
+        }
+        return nil
+    })
+    fmt.Println(n, err == errStop, db.Stats().InUse)
+    c := PersonColumns
+    it, err := m.Query().Where(c.Age.Gt(30)).OrderBy(c.Age.Desc()).Iter()
+    if err != nil {
+        log.Fatal(err)
+    }
+    for it.Next() {
+        fmt.Println(it.Value().Name)
+    }
+    if err := it.Err(); err != nil {
+        log.Fatal(err)
+    }
+    for p, err := range m.Query().OrderBy(c.ID.Asc()).Seq() {
+        if err != nil {
+            log.Fatal(err)
+        }
+        fmt.Println(p.Name)
+        if p.ID == 3 {
+            break
+        }
+    }
+    fmt.Println(db.Stats().InUse)
+    n = 0
+    for _, err := range m.Seq() {
+        if err != nil {
+            log.Fatal(err)
+        }
+        n++
+    }
+    fmt.Println(n, db.Stats().InUse)
+}
+`,
+	Expected: "2 true 0\nGrace Hopper\nBrian Eno\nAlan Turing\nPaul Smith\nPaul Smith\nBrian Eno\nAda Lovelace\n0\n5 0\n",
+}
+
+var pageTest = CodeGenTest{
+	CleanupSQL:    findBy.CleanupSQL,
+	TableSetupSQL: query.TableSetupSQL,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "page_by": ["age", "id"],
+        "columns": [
+            {"field": "ID", "column": "id", "type": "integer", "pk": true},
+            {"field": "Name", "column": "name", "type": "varchar(100)"},
+            {"field": "Email", "column": "email", "type": "varchar(100)", "null": true},
+            {"field": "Age", "column": "age", "type": "integer"}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    var cursor PersonCursor
+    for {
+        people, next, err := m.Page(cursor, 2)
+        if err != nil {
+            log.Fatal(err)
+        }
+        for _, p := range people {
+            fmt.Printf("%s ", p.Name)
+        }
+        fmt.Println(len(people))
+        if next == "" {
+            break
+        }
+        cursor = next
+    }
+    _, _, err = m.Page("bm90IGEgY3Vyc29y", 2)
+    fmt.Println(errors.Is(err, ErrInvalidCursor))
+    _, _, err = m.Page(PersonCursor(cursor[1:]), 2)
+    fmt.Println(errors.Is(err, ErrInvalidCursor))
+}
+`,
+	Expected: "Ada Lovelace Paul Smith 2\nAlan Turing Brian Eno 2\nGrace Hopper 1\ntrue\ntrue\n",
+}
+
+var versionTest = CodeGenTest{
+	CleanupSQL: `DROP TABLE doc`,
+	Metadata: `
+[
+    {
+        "struct": "Doc",
+        "table": "doc",
+        "version": "Version",
+        "upsert": {},
+        "columns": [
+            {"field": "ID", "column": "id", "type": "integer", "pk": true},
+            {"field": "Title", "column": "title", "type": "varchar(100)"},
+            {"field": "Version", "column": "version", "type": "integer"}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewDocMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := m.Insert(&Doc{ID: 1, Title: "Draft"}); err != nil {
+        log.Fatal(err)
+    }
+    a, err := m.Get(1)
+    if err != nil {
+        log.Fatal(err)
+    }
+    b, err := m.Get(1)
+    if err != nil {
+        log.Fatal(err)
+    }
+    a.Title = "First"
+    if err := m.Update(a); err != nil {
+        log.Fatal(err)
+    }
+    b.Title = "Second"
+    err = m.Update(b)
+    fmt.Println(a.Version, b.Version, errors.Is(err, ErrStaleObject), errors.Is(err, ErrNotFound))
+    err = m.Update(&Doc{ID: 2, Title: "Missing"})
+    fmt.Println(errors.Is(err, ErrStaleObject))
+    doc := &Doc{ID: 1, Title: "Third"}
+    if err := m.Upsert(doc); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(doc.Title, doc.Version)
+    docs := []*Doc{{ID: 1, Title: "Fourth"}, {ID: 2, Title: "New"}}
+    if err := m.UpsertMany(docs); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(docs[0].Version, docs[1].Version)
+    docs[0].Title = "Fifth"
+    if err := m.Update(docs[0]); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(docs[0].Version)
+    docs[0].Title = "Sixth"
+    err = m.UpdateMany([]*Doc{docs[0], {ID: 2, Title: "Stale", Version: 7}})
+    fmt.Println(errors.Is(err, ErrStaleObject), docs[0].Version)
+    if err := m.UpdateMany(docs); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(docs[0].Version, docs[1].Version)
+}
+`,
+	Expected: "1 0 true false\ntrue\nThird 2\n3 0\n4\ntrue 4\n5 1\n",
+}
+
+var softDelete = CodeGenTest{
+	CleanupSQL:    `DROP TABLE note`,
+	TableSetupSQL: `INSERT INTO note (id, body) VALUES (1, 'a'), (2, 'b'), (3, 'c')`,
+	Metadata: `
+[
+    {
+        "struct": "Note",
+        "table": "note",
+        "soft_delete": "deleted_at",
+        "upsert": {},
+        "columns": [
+            {"field": "ID", "column": "id", "type": "integer", "pk": true},
+            {"field": "Body", "column": "body", "type": "varchar(100)"},
+            {"field": "DeletedAt", "column": "deleted_at", "type": "timestamp", "null": true}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "reflect"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewNoteMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := m.Delete(&Note{ID: 1}); err != nil {
+        log.Fatal(err)
+    }
+    err = m.Delete(&Note{ID: 1})
+    fmt.Println(errors.Is(err, ErrNotFound))
+    _, err = m.Get(1)
+    fmt.Println(errors.Is(err, ErrNotFound))
+    note, err := m.WithDeleted().Get(1)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(note.Body, note.DeletedAt != nil)
+    err = m.Update(&Note{ID: 1, Body: "edited"})
+    fmt.Println(errors.Is(err, ErrNotFound))
+    if note, err = m.WithDeleted().Get(1); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(note.Body, note.DeletedAt != nil)
+    notes, err := m.All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    n, err := m.Query().Count()
+    if err != nil {
+        log.Fatal(err)
+    }
+    unscoped := m.WithDeleted()
+    all, err := unscoped.Query().Count()
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := unscoped.Close(); err != nil {
+        log.Fatal(err)
+    }
+    if _, err := m.Get(2); err != nil {
+        log.Fatal(err)
+    }
+    _, finder := reflect.TypeOf(m).MethodByName("FindByDeletedAt")
+    fmt.Println(finder)
+    found, err := m.FindWhere("body <> 'x' OR body = 'a'")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(len(notes), n, all, len(found))
+    deleted, err := m.DeleteWhere("body = 'b' OR body = 'a'")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(deleted)
+    if err := m.Restore(note); err != nil {
+        log.Fatal(err)
+    }
+    err = m.Restore(note)
+    fmt.Println(errors.Is(err, ErrNotFound))
+    deleted, err = m.DeleteMany([]*Note{{ID: 1}, {ID: 2}})
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := m.HardDelete(&Note{ID: 3}); err != nil {
+        log.Fatal(err)
+    }
+    notes, err = m.WithDeleted().All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(deleted, len(notes))
+    if err := m.Upsert(&Note{ID: 2, Body: "revived"}); err != nil {
+        log.Fatal(err)
+    }
+    if err := m.UpsertMany([]*Note{{ID: 1, Body: "again"}, {ID: 4, Body: "new"}}); err != nil {
+        log.Fatal(err)
+    }
+    for _, id := range []int64{1, 2, 4} {
+        note, err := m.Get(id)
+        if err != nil {
+            log.Fatal(err)
+        }
+        fmt.Println(note.ID, note.Body)
+    }
+}
+`,
+	Expected: "true\ntrue\na true\ntrue\na true\nfalse\n2 2 3 2\n1\ntrue\n1 2\n1 again\n2 revived\n4 new\n",
+}
+
+var textKey = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE page (slug varchar PRIMARY KEY, title varchar)`,
+	CleanupSQL:     `DROP TABLE page`,
+	Metadata:       `[{"struct": "Page", "table": "page", "columns": [{"field": "Slug", "column": "slug", "type": "varchar", "pk": true}, {"field": "Title", "column": "title", "type": "varchar"}]}]`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Page struct {
+    Slug  string
+    Title string
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPageMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := m.Insert(&Page{"about", "About us"}); err != nil {
+        log.Fatal(err)
+    }
+    p, err := m.Get("about")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s\n", p.Title)
+}
+`,
+	Expected: "About us\n",
+}
+
+var table = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE foo (id serial)`,
+	CleanupSQL:     `DROP TABLE foo`,
+	Metadata:       `[{"struct": "Foo", "table": "foo", "columns": [{"field": "ID", "column": "id", "pk": true}]}]`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Foo struct {
+    ID int64
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewFooMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s\n", m.Table())
+}
+`,
+	Expected: "foo\n",
+}
+
+var structsTest = CodeGenTest{
+	CleanupSQL: insert.CleanupSQL,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "null_pointers": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "int",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar"
+        }, {
+            "field": "Email",
+            "column": "email",
+            "type": "varchar",
+            "null": true,
+            "finder": "one"
+        }, {
+            "field": "Born",
+            "column": "born",
+            "type": "timestamp",
+            "null": true,
+            "finder": false
+        }]
+    }
+]
+`,
+	Structs: &Structs{Tags: []string{"json", "db"}},
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+    "reflect"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    email := "brian@example.com"
+    people := []*Person{
+        {ID: 1, Name: "Paul Smith"},
+        {ID: 2, Name: "Brian Eno", Email: &email},
+    }
+    if err := m.InsertMany(people); err != nil {
+        log.Fatal(err)
+    }
+    p, err := m.Get(1)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s %v %v\n", p.Name, p.Email == nil, p.Born == nil)
+    p, err = m.FindOneByEmail(&email)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s %s\n", p.Name, *p.Email)
+    field, _ := reflect.TypeOf(Person{}).FieldByName("Born")
+    fmt.Printf("%s %s\n", field.Type, field.Tag)
+}
+`,
+	Expected: "Paul Smith true true\nBrian Eno brian@example.com\n*time.Time json:\"born\" db:\"born\"\n",
+}
+
+var schemaMetadata = `
+[
+    {
+        "struct": "Account",
+        "table": "account",
+        "auto_pk": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "Email",
+            "column": "email",
+            "type": "varchar(100)",
+            "unique": true
+        }, {
+            "field": "Plan",
+            "column": "plan",
+            "type": "varchar(20)",
+            "default": "'free'"
+        }, {
+            "field": "Team",
+            "column": "team",
+            "type": "varchar(20)",
+            "null": true
+        }],
+        "indexes": [{
+            "columns": ["team", "plan"]
+        }]
+    }
+]
+`
+
+var schemaTest = CodeGenTest{
+	CleanupSQL: `DROP TABLE account`,
+	Metadata:   schemaMetadata,
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+)
+
+type Account struct {
+    ID    int64
+    Email string
+    Plan  string
+    Team  sql.NullString
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewAccountMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    a := Account{Email: "paul@example.com", Plan: "pro"}
+    if err := m.Insert(&a); err != nil {
+        log.Fatal(err)
+    }
+    if _, err := db.Exec("INSERT INTO account (email) VALUES ('brian@example.com')"); err != nil {
+        log.Fatal(err)
+    }
+    b, err := m.FindOneByEmail("brian@example.com")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%d %s %d %s %v\n", a.ID, a.Plan, b.ID, b.Plan, b.Team.Valid)
+    dup := Account{Email: "paul@example.com", Plan: "pro"}
+    fmt.Printf("duplicate: %v\n", m.Insert(&dup) != nil)
+}
+`,
+	Expected: "1 pro 2 free false\nduplicate: true\n",
+}
+
+var references = CodeGenTest{
+	CleanupSQL: `DROP TABLE post; DROP TABLE person`,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "auto_pk": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar(100)"
+        }]
+    },
+    {
+        "struct": "Post",
+        "table": "post",
+        "auto_pk": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "AuthorID",
+            "column": "author_id",
+            "type": "integer",
+            "references": {"struct": "Person", "field": "ID"}
+        }, {
+            "field": "EditorID",
+            "column": "editor_id",
+            "type": "integer",
+            "null": true,
+            "references": {"struct": "Person", "field": "ID", "inverse": "EditedPosts"}
+        }, {
+            "field": "Title",
+            "column": "title",
+            "type": "varchar(100)"
+        }]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    people, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    posts, err := NewPostMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    paul, brian := &Person{Name: "Paul Smith"}, &Person{Name: "Brian Eno"}
+    if err := people.InsertMany([]*Person{paul, brian}); err != nil {
+        log.Fatal(err)
+    }
+    objs := []*Post{
+        {AuthorID: paul.ID, EditorID: sql.NullInt64{Int64: brian.ID, Valid: true}, Title: "Ambient"},
+        {AuthorID: brian.ID, Title: "Oblique"},
+        {AuthorID: paul.ID, Title: "Strategies"},
+    }
+    if err := posts.InsertMany(objs); err != nil {
+        log.Fatal(err)
+    }
+    author, err := posts.LoadAuthor(objs[1])
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("author: %s\n", author.Name)
+    _, err = posts.LoadEditor(objs[1])
+    var merr *MapperError
+    fmt.Println(errors.Is(err, ErrNotFound), errors.As(err, &merr) && merr.Op == "LoadEditor" && merr.Table == "person")
+    written, err := people.Posts(paul)
+    if err != nil {
+        log.Fatal(err)
+    }
+    edited, err := people.EditedPosts(brian)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("written: %d, edited: %d %s\n", len(written), len(edited), edited[0].Title)
+    authors, err := posts.LoadAuthors(objs)
+    if err != nil {
+        log.Fatal(err)
+    }
+    editors, err := posts.LoadEditors(objs)
+    if err != nil {
+        log.Fatal(err)
+    }
+    for i := range objs {
+        fmt.Printf("%s %s %v\n", objs[i].Title, authors[i].Name, editors[i] != nil)
+    }
+}
+`,
+	Expected: "author: Brian Eno\ntrue true\nwritten: 2, edited: 1 Ambient\nAmbient Paul Smith true\nOblique Brian Eno false\nStrategies Paul Smith false\n",
+}
+
+var upsertTest = CodeGenTest{
+	CleanupSQL: `DROP TABLE person; DROP TABLE setting`,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "auto_pk": true,
+        "upsert": {"conflict": ["email"], "update": ["name"]},
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "Email",
+            "column": "email",
+            "type": "varchar(100)",
+            "unique": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar(100)"
+        }, {
+            "field": "Visits",
+            "column": "visits",
+            "type": "integer"
+        }]
+    },
+    {
+        "struct": "Setting",
+        "table": "setting",
+        "upsert": {},
+        "columns": [{
+            "field": "Key",
+            "column": "key",
+            "type": "varchar(100)",
+            "pk": true
+        }, {
+            "field": "Value",
+            "column": "value",
+            "type": "varchar(100)"
+        }]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    people, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    settings, err := NewSettingMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    paul := &Person{Email: "paul@example.com", Name: "Paul", Visits: 1}
+    if err := people.Upsert(paul); err != nil {
+        log.Fatal(err)
+    }
+    again := &Person{Email: "paul@example.com", Name: "Paul Smith", Visits: 2}
+    if err := people.Upsert(again); err != nil {
+        log.Fatal(err)
+    }
+    objs := []*Person{
+        {Email: "brian@example.com", Name: "Brian Eno", Visits: 1},
+        {Email: "paul@example.com", Name: "P. Smith", Visits: 3},
+    }
+    if err := people.UpsertMany(objs); err != nil {
+        log.Fatal(err)
+    }
+    obj, err := people.Get(paul.ID)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(again.ID == paul.ID, objs[1].ID == paul.ID, objs[0].ID != paul.ID)
+    fmt.Printf("%s %d\n", obj.Name, obj.Visits)
+    dups := []*Person{
+        {Email: "alan@example.com", Name: "Alan", Visits: 1},
+        {Email: "alan@example.com", Name: "Alan Turing", Visits: 2},
+        {Email: "brian@example.com", Name: "Brian", Visits: 2},
+    }
+    if err := people.UpsertMany(dups); err != nil {
+        log.Fatal(err)
+    }
+    if obj, err = people.Get(dups[0].ID); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(dups[0].ID == dups[1].ID, dups[2].ID == objs[0].ID)
+    fmt.Printf("%s %d\n", obj.Name, obj.Visits)
+    if err := settings.UpsertMany([]*Setting{{"theme", "dark"}, {"lang", "en"}}); err != nil {
+        log.Fatal(err)
+    }
+    if err := settings.UpsertMany([]*Setting{{"theme", "light"}, {"tz", "UTC"}}); err != nil {
+        log.Fatal(err)
+    }
+    all, err := settings.All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, s := range all {
+        fmt.Printf("%s=%s\n", s.Key, s.Value)
+    }
+}
+`,
+	Expected: "true true true\nP. Smith 1\ntrue true\nAlan Turing 1\ntheme=light\nlang=en\ntz=UTC\n",
+}
+
+var upsertManyRollback = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE counter (name varchar(100) PRIMARY KEY, n integer NOT NULL CHECK (n >= 0), version integer NOT NULL)`,
+	CleanupSQL:     `DROP TABLE counter`,
+	TableSetupSQL:  `INSERT INTO counter (name, n, version) VALUES ('a', 1, 5)`,
+	Metadata: `
+[
+    {
+        "struct": "Counter",
+        "table": "counter",
+        "version": "Version",
+        "upsert": {},
+        "columns": [
+            {"field": "Name", "column": "name", "type": "varchar(100)", "pk": true},
+            {"field": "N", "column": "n", "type": "integer"},
+            {"field": "Version", "column": "version", "type": "integer"}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewCounterMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    a := &Counter{Name: "a", N: 2, Version: 5}
+    err = m.UpsertMany([]*Counter{a, {Name: "b", N: -1}})
+    fmt.Println(err != nil, a.Version)
+    if err := m.UpsertMany([]*Counter{a}); err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(a.Version)
+}
+`,
+	Expected: "true 5\n6\n",
+}
+
+var upsertBlobKey = CodeGenTest{
+	CreateTableSQLFor: map[string]string{
+		"mysql": `CREATE TABLE blob_setting (k varbinary(64) PRIMARY KEY, value varchar(100) NOT NULL)`,
+	},
+	CleanupSQL: `DROP TABLE blob_setting`,
+	Metadata: `
+[
+    {
+        "struct": "BlobSetting",
+        "table": "blob_setting",
+        "upsert": {},
+        "columns": [
+            {"field": "Key", "column": "k", "type": "bytea", "pk": true},
+            {"field": "Value", "column": "value", "type": "varchar(100)"}
+        ]
+    }
+]
+`,
+	Structs: &Structs{},
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    m, err := NewBlobSettingMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    objs := []*BlobSetting{
+        {[]byte("theme"), "dark"},
+        {[]byte("lang"), "en"},
+        {[]byte("theme"), "light"},
+    }
+    if err := m.UpsertMany(objs); err != nil {
+        log.Fatal(err)
+    }
+    all, err := m.All()
+    if err != nil {
+        log.Fatal(err)
+    }
+    obj, err := m.Get([]byte("theme"))
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(len(all), obj.Value)
+}
+`,
+	Expected: "2 light\n",
+}
+
+var embedded = CodeGenTest{
+	CleanupSQL: `DROP TABLE person`,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "auto_pk": true,
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar(100)",
+            "finder": false
+        }, {
+            "field": "Audit.CreatedBy",
+            "column": "audit_created_by",
+            "type": "varchar(100)"
+        }, {
+            "field": "Audit.Revision",
+            "column": "audit_revision",
+            "type": "integer",
+            "finder": false
+        }]
+    }
+]
+`,
+	DriverCode: `
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+type Audit struct {
+    CreatedBy string
+    Revision  int64
+}
+
+type Person struct {
+    ID   int64
+    Name string
+    Audit
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    mapper, err := NewPersonMapper(db)
+    if err != nil {
+        log.Fatal(err)
+    }
+    paul := &Person{Name: "Paul Smith", Audit: Audit{CreatedBy: "admin", Revision: 1}}
+    if err := mapper.Insert(paul); err != nil {
+        log.Fatal(err)
+    }
+    objs := []*Person{
+        {Name: "Brian Eno", Audit: Audit{CreatedBy: "import"}},
+        {Name: "Alan Turing", Audit: Audit{CreatedBy: "import"}},
+    }
+    if err := mapper.InsertMany(objs); err != nil {
+        log.Fatal(err)
+    }
+    paul.Revision++
+    if err := mapper.Update(paul); err != nil {
+        log.Fatal(err)
+    }
+    obj, err := mapper.Get(paul.ID)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Printf("%s %s %d\n", obj.Name, obj.CreatedBy, obj.Revision)
+    imported, err := mapper.FindByCreatedBy("import")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(len(imported))
+    first, err := mapper.Query().Where(PersonColumns.CreatedBy.Eq("import")).OrderBy(PersonColumns.Name.Asc()).First()
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(first.Name)
+}
+`,
+	Expected: "Paul Smith admin 2\n2\nAlan Turing\n",
+}
+
+var verify = CodeGenTest{
+	CreateTableSQL: `CREATE TABLE person (id int, name varchar(100) not null, email boolean, age int)`,
+	CleanupSQL:     insert.CleanupSQL,
+	Metadata: `
+[
+    {
+        "struct": "Person",
+        "table": "person",
+        "columns": [{
+            "field": "ID",
+            "column": "id",
+            "type": "integer",
+            "pk": true
+        }, {
+            "field": "Name",
+            "column": "name",
+            "type": "varchar(100)"
+        }, {
+            "field": "Email",
+            "column": "email",
+            "type": "varchar(100)",
+            "null": true
+        }, {
+            "field": "Nick",
+            "column": "nick",
+            "type": "varchar(100)",
+            "null": true
+        }]
+    }
+]
+`,
+	DriverCode: `
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+)
+
+type Person struct {
+    ID    int64
+    Name  string
+    Email sql.NullString
+    Nick  sql.NullString
+}
+
+func main() {
+    db, err := openDB()
+    if err != nil {
+        log.Fatal(err)
+    }
+    // Preparing the mapper's statements would fail on the missing column.
+    m := &PersonMapper{db: db}
+    err = m.Verify()
+    if err, ok := err.(*SchemaError); ok {
+        for _, problem := range err.Problems {
+            fmt.Println(problem)
+        }
+    }
+}
+`,
+	Expected: `column id is nullable, want NOT NULL
+column id is not in the primary key
+column email has type boolean, want varchar
+missing column nick
+`,
+}
+
+type CodeGenTest struct {
+	// CreateTableSQL, if empty, is generated from the metadata by Schema.
 	CreateTableSQL string
-	TableSetupSQL  string
-	CleanupSQL     string
-	Metadata       string
-	DriverCode     string
-	Expected       string
+	// CreateTableSQLFor overrides CreateTableSQL for the named dialects.
+	CreateTableSQLFor map[string]string
+	TableSetupSQL     string
+	CleanupSQL        string
+	Metadata          string
+	GoVersion         string // of the generated code, see Code
+	// Structs, if set, generates the struct types of the metadata, rather
+	// than the driver code declaring them.
+	Structs    *Structs
+	DriverCode string
+	Expected   string
+}
+
+func (test CodeGenTest) createTableSQL(d Dialect) string {
+	if sql, ok := test.CreateTableSQLFor[d.Name()]; ok {
+		return sql
+	}
+	return test.CreateTableSQL
 }
 
-func testCodeGen(t *testing.T, test CodeGenTest) {
-	db := openTestDB(t)
+func testCodeGen(t *testing.T, b testBackend, test CodeGenTest) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	dsn := b.dataSource(dir)
+	db := openTestDB(t, b, dsn)
 	defer db.Close()
 
-	_, err := db.Exec(test.CreateTableSQL)
+	mapper, err := NewMap(strings.NewReader(test.Metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createSQL := test.createTableSQL(b.dialect)
+	if createSQL == "" {
+		var buf bytes.Buffer
+		schema := Schema{Dialect: b.dialect}
+		if err := schema.Gen(mapper, &buf); err != nil {
+			t.Fatal(err)
+		}
+		createSQL = buf.String()
+	}
+	_, err = db.Exec(createSQL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -516,35 +2376,57 @@ func testCodeGen(t *testing.T, test CodeGenTest) {
 		}
 	}
 
-	mapper, err := NewMap(strings.NewReader(test.Metadata))
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	dir := tempDir(t)
 	genCodeFile := tempGoFile(dir, t)
 	driverCodeFile := tempGoFile(dir, t)
 	supportFile := tempGoFile(dir, t)
+	openDBFile := tempGoFile(dir, t)
 
 	defer func() {
 		genCodeFile.Close()
 		driverCodeFile.Close()
 		supportFile.Close()
-		os.RemoveAll(dir)
+		openDBFile.Close()
 	}()
 
 	code := NewCode()
-	code.Gen(mapper, "main", genCodeFile)
+	code.Dialect = b.dialect
+	code.GoVersion = test.GoVersion
+	if err := code.Gen(mapper, "main", genCodeFile); err != nil {
+		t.Fatal(err)
+	}
 
 	if _, err := driverCodeFile.WriteString(test.DriverCode); err != nil {
 		t.Fatal(err)
 	}
 
-	GenSupport(supportFile, "main")
+	if err := GenSupport(supportFile, "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if test.Structs != nil {
+		structsFile := tempGoFile(dir, t)
+		defer structsFile.Close()
+		if err := test.Structs.Gen(mapper, "main", structsFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var placeholders []string
+	for i := 1; i <= 9; i++ {
+		placeholders = append(placeholders, b.dialect.Placeholder(i))
+	}
+	err = openDBTemplate.Execute(openDBFile, struct {
+		DriverPkg, Driver, DataSource string
+		Placeholders                  []string
+	}{b.driverPkg, b.driver, dsn, placeholders})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	genCodeFile.Sync()
 	driverCodeFile.Sync()
 	supportFile.Sync()
+	openDBFile.Sync()
 
 	goFiles, err := filepath.Glob(filepath.Join(dir, "*.go"))
 	if err != nil {
@@ -572,17 +2454,509 @@ func testCodeGen(t *testing.T, test CodeGenTest) {
 
 func TestCodeGen(t *testing.T) {
 	var tests = map[string]CodeGenTest{
-		"Get":        get,
-		"All":        all,
-		"Insert":     insert,
-		"Update":     update,
-		"InsertMany": insertMany,
-		"Delete":     deleteTest,
-		"FindWhere":  findWhere,
-		"Table":      table,
+		"Get":                    get,
+		"GetContext":             getContext,
+		"All":                    all,
+		"Insert":                 insert,
+		"InsertAuto":             insertAutoPK,
+		"Update":                 update,
+		"InsertMany":             insertMany,
+		"InsertManyBatch":        insertManyBatch,
+		"InsertManyRollback":     insertManyRollback,
+		"InsertManyAutoRollback": insertManyAutoRollback,
+		"Tx":                     txTest,
+		"Delete":                 deleteTest,
+		"FindWhere":              findWhere,
+		"FindBy":                 findBy,
+		"Query":                  query,
+		"CompositeKey":           compositeKey,
+		"TextKey":                textKey,
+		"Table":                  table,
+		"Structs":                structsTest,
+		"Schema":                 schemaTest,
+		"References":             references,
+		"Embedded":               embedded,
+		"Upsert":                 upsertTest,
+		"UpsertBlobKey":          upsertBlobKey,
+		"UpsertManyRollback":     upsertManyRollback,
+		"Bulk":                   bulk,
+		"Errors":                 errorsTest,
+		"Iter":                   iterTest,
+		"Page":                   pageTest,
+		"Version":                versionTest,
+		"SoftDelete":             softDelete,
+		"Verify":                 verify,
+		"PrepareErr":             prepareError,
 	}
+	b := backend(t)
 	for name, test := range tests {
 		t.Log(name)
-		testCodeGen(t, test)
+		testCodeGen(t, b, test)
+	}
+}
+
+func TestGenMapError(t *testing.T) {
+	var tests = []struct {
+		metadata string
+		want     string
+	}{
+		{
+			`[{"struct": "T", "table": "t", "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "", "column": "val"}]}]`,
+			`table 0 (T), column 1 (): field name "" is not a Go identifier`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "columns": [{"field": "ID", "column": "id"}]}, {"struct": "U", "table": "u", "columns": [{"field": "ID", "column": "id"}, {"field": "Val", "column": "id"}]}]`,
+			`table 1 (U), column 1 (Val): column id mapped more than once`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "dialect": "oracle", "columns": [{"field": "ID", "column": "id"}]}]`,
+			`table 0 (T): unknown dialect "oracle" (have mysql, postgres, sqlite)`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "columns": [{"field": "ID", "column": "id"}, {"field": "UID", "column": "u_id", "references": {"struct": "U", "field": "ID"}}]}]`,
+			`table 0 (T), column 1 (UID): references unmapped struct U`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "columns": [{"field": "ID", "column": "id"}, {"field": "A.ID", "column": "a_id"}]}]`,
+			`table 0 (T), column 1 (A.ID): field name ID is not unique among the fields of the struct`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "upsert": {"conflict": ["val"]}, "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "Val", "column": "val"}]}]`,
+			`table 0 (T): upsert: conflict columns val are not the primary key, a unique column or a unique index`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "page_by": ["val"], "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "Val", "column": "val"}]}]`,
+			`table 0 (T): page_by: columns val do not include the primary key and are not a unique key`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "version": "Rev", "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "Rev", "column": "rev", "type": "integer", "null": true}]}]`,
+			`table 0 (T): version field Rev is nullable`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "auto_pk": true, "columns": [{"field": "ID", "column": "id", "type": "uuid", "pk": true}, {"field": "Val", "column": "val"}]}]`,
+			`table 0 (T): auto_pk set but primary key column id has Go type string, not an integer type, and no default`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "dialect": "sqlite", "auto_pk": true, "columns": [{"field": "ID", "column": "id", "type": "uuid", "pk": true, "default": "gen_random_uuid()"}, {"field": "Val", "column": "val"}]}]`,
+			`table 0 (T): auto_pk set but primary key column id has Go type string, not an integer type, which sqlite cannot return`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "dialect": "mysql", "auto_pk": true, "columns": [{"field": "ID", "column": "id", "type": "varchar(36)", "pk": true, "default": "(uuid())"}, {"field": "Val", "column": "val"}]}]`,
+			`table 0 (T): auto_pk set but primary key column id has Go type string, not an integer type, which mysql cannot return`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "soft_delete": "deleted_at", "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "DeletedAt", "column": "deleted_at", "type": "timestamp"}]}]`,
+			`table 0 (T): soft_delete column deleted_at is not nullable`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "soft_delete": "deleted_at", "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "DeletedAt", "column": "deleted_at", "type": "timestamp", "null": true, "finder": "many"}]}]`,
+			`table 0 (T): soft_delete column deleted_at cannot have a finder`,
+		},
+		{
+			`[{"struct": "T", "table": "t", "soft_delete": "deleted_at", "upsert": {"update": ["deleted_at"]}, "columns": [{"field": "ID", "column": "id", "pk": true}, {"field": "DeletedAt", "column": "deleted_at", "type": "timestamp", "null": true}]}]`,
+			`table 0 (T): upsert: column deleted_at is the soft delete column`,
+		},
+	}
+	for _, test := range tests {
+		mapper, err := NewMap(strings.NewReader(test.metadata))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewCode().Gen(mapper, "main", ioutil.Discard)
+		if _, ok := err.(*MapError); !ok {
+			t.Errorf("want *MapError, got %T", err)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("want %q, got %q", test.want, err)
+		}
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	b := backend(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, b, b.dataSource(dir))
+	defer db.Close()
+
+	test := CodeGenTest{
+		CreateTableSQL: `CREATE TABLE person (id serial primary key, name varchar not null, email varchar(64), age int)`,
+		CreateTableSQLFor: map[string]string{
+			"sqlite": `CREATE TABLE person (id integer primary key autoincrement, name varchar not null, email varchar(64), age int)`,
+		},
+	}
+	if _, err := db.Exec(test.createTableSQL(b.dialect)); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(insert.CleanupSQL)
+
+	types := map[string][]string{
+		"postgres": {"integer", "character varying", "character varying(64)", "integer"},
+		"sqlite":   {"integer", "varchar", "varchar(64)", "int"},
+	}[b.dialect.Name()]
+	want := TableMap{
+		Struct: "Person",
+		Table:  "person",
+		Columns: []ColumnMap{
+			{Field: "ID", Column: "id", Type: types[0], PrimaryKey: true},
+			{Field: "Name", Column: "name", Type: types[1]},
+			{Field: "Email", Column: "email", Type: types[2], Null: true},
+			{Field: "Age", Column: "age", Type: types[3], Null: true},
+		},
+		AutoPK: true,
+	}
+	if b.dialect != Postgres {
+		want.Dialect = b.dialect.Name()
+	}
+
+	mapper, err := Introspect(db, b.dialect, "person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapper) != 1 {
+		t.Fatalf("want 1 table map, got %d", len(mapper))
+	}
+	if !reflect.DeepEqual(mapper[0], want) {
+		t.Errorf("want %+v, got %+v", want, mapper[0])
+	}
+
+	if _, err := Introspect(db, b.dialect, "nonesuch"); err == nil {
+		t.Error("want error introspecting missing table")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	var tests = map[Dialect]string{
+		Postgres: `CREATE TABLE "account" (
+    "id" serial NOT NULL,
+    "email" varchar(100) NOT NULL UNIQUE,
+    "plan" varchar(20) NOT NULL DEFAULT 'free',
+    "team" varchar(20),
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "account_team_plan_idx" ON "account" ("team", "plan");
+
+`,
+		MySQL: "CREATE TABLE `account` (\n" +
+			"    `id` integer NOT NULL AUTO_INCREMENT,\n" +
+			"    `email` varchar(100) NOT NULL UNIQUE,\n" +
+			"    `plan` varchar(20) NOT NULL DEFAULT 'free',\n" +
+			"    `team` varchar(20),\n" +
+			"    PRIMARY KEY (`id`)\n" +
+			");\n\n" +
+			"CREATE INDEX `account_team_plan_idx` ON `account` (`team`, `plan`);\n\n",
+		SQLite: `CREATE TABLE "account" (
+    "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+    "email" varchar(100) NOT NULL UNIQUE,
+    "plan" varchar(20) NOT NULL DEFAULT 'free',
+    "team" varchar(20)
+);
+
+CREATE INDEX "account_team_plan_idx" ON "account" ("team", "plan");
+
+`,
+	}
+	mapper, err := NewMap(strings.NewReader(schemaMetadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d, want := range tests {
+		var buf bytes.Buffer
+		schema := Schema{Dialect: d}
+		if err := schema.Gen(mapper, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%s: want %q, got %q", d.Name(), want, got)
+		}
+	}
+}
+
+func TestDiffMaps(t *testing.T) {
+	have := Map{{
+		Struct: "Person",
+		Table:  "person",
+		Columns: []ColumnMap{
+			{Field: "ID", Column: "id", Type: "integer", PrimaryKey: true},
+			{Field: "Name", Column: "name", Type: "character varying"},
+			{Field: "Nick", Column: "nick", Type: "character varying", Null: true},
+			{Field: "Age", Column: "age", Type: "integer"},
+			{Field: "Bio", Column: "bio", Type: "character varying(64)", Null: true},
+		},
+	}}
+	want := Map{{
+		Struct: "Person",
+		Table:  "person",
+		Columns: []ColumnMap{
+			{Field: "ID", Column: "id", Type: "int", PrimaryKey: true},
+			{Field: "FullName", Column: "full_name", Type: "varchar(100)", RenamedFrom: "name"},
+			{Field: "Age", Column: "age", Type: "bigint", Null: true},
+			{Field: "Email", Column: "email", Type: "varchar(100)", Null: true},
+			{Field: "Bio", Column: "bio", Type: "text", Null: true},
+		},
+	}, {
+		Struct: "Tag",
+		Table:  "tag",
+		Columns: []ColumnMap{
+			{Field: "Name", Column: "name", Type: "varchar(50)", PrimaryKey: true},
+		},
+	}}
+	wantUp := `ALTER TABLE "person" RENAME COLUMN "name" TO "full_name";
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" ALTER COLUMN "age" TYPE bigint;
+
+ALTER TABLE "person" ALTER COLUMN "age" DROP NOT NULL;
+
+ALTER TABLE "person" ADD COLUMN "email" varchar(100);
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" ALTER COLUMN "bio" TYPE text;
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" DROP COLUMN "nick";
+
+CREATE TABLE "tag" (
+    "name" varchar(50) NOT NULL,
+    PRIMARY KEY ("name")
+);
+
+`
+	wantDown := `-- DESTRUCTIVE: may lose data
+DROP TABLE "tag";
+
+ALTER TABLE "person" ADD COLUMN "nick" character varying;
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" ALTER COLUMN "bio" TYPE character varying(64);
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" DROP COLUMN "email";
+
+ALTER TABLE "person" ALTER COLUMN "age" SET NOT NULL;
+
+-- DESTRUCTIVE: may lose data
+ALTER TABLE "person" ALTER COLUMN "age" TYPE integer;
+
+ALTER TABLE "person" RENAME COLUMN "full_name" TO "name";
+
+`
+	m, err := DiffMaps(have, want, Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var up, down bytes.Buffer
+	if err := m.WriteUp(&up); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteDown(&down); err != nil {
+		t.Fatal(err)
+	}
+	if up.String() != wantUp {
+		t.Errorf("up: want %q, got %q", wantUp, up.String())
+	}
+	if down.String() != wantDown {
+		t.Errorf("down: want %q, got %q", wantDown, down.String())
+	}
+
+	if _, err := DiffMaps(have, want, SQLite); err == nil {
+		t.Error("want error changing column type in sqlite")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	b := backend(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, b, b.dataSource(dir))
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE person (id integer primary key, name varchar(100) not null)`); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(insert.CleanupSQL)
+
+	want := Map{{
+		Struct: "Person",
+		Table:  "person",
+		Columns: []ColumnMap{
+			{Field: "ID", Column: "id", Type: "integer", PrimaryKey: true},
+			{Field: "FullName", Column: "full_name", Type: "varchar(100)", RenamedFrom: "name"},
+			{Field: "Email", Column: "email", Type: "varchar(100)", Null: true},
+		},
+	}}
+	exec := func(steps []Step) {
+		for _, step := range steps {
+			if _, err := db.Exec(step.SQL); err != nil {
+				t.Fatalf("%s: %v", step.SQL, err)
+			}
+		}
+	}
+
+	m, err := Diff(db, b.dialect, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Up) != 2 {
+		t.Fatalf("want 2 up steps, got %+v", m.Up)
+	}
+	exec(m.Up)
+	if again, err := Diff(db, b.dialect, want); err != nil || !again.Empty() {
+		t.Fatalf("want no changes after migrating up, got %+v, %v", again, err)
+	}
+
+	if b.dialect == SQLite {
+		// Dropping the added column needs SQLite 3.35.
+		return
+	}
+	exec(m.Down)
+	again, err := Diff(db, b.dialect, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Up, m.Up) {
+		t.Errorf("want %+v after migrating down, got %+v", m.Up, again.Up)
+	}
+}
+
+func TestMigrationWriteFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "0007_init.up.sql"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	m := Migration{
+		Up:   []Step{{SQL: "ALTER TABLE t ADD COLUMN c int"}},
+		Down: []Step{{SQL: "ALTER TABLE t DROP COLUMN c", Destructive: true}},
+	}
+	up, down, err := m.WriteFiles(dir, "add_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "0008_add_c.up.sql"); up != want {
+		t.Errorf("want %s, got %s", want, up)
+	}
+	data, err := ioutil.ReadFile(down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-- DESTRUCTIVE: may lose data\nALTER TABLE t DROP COLUMN c;\n\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	b := backend(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, b, b.dataSource(dir))
+	defer db.Close()
+
+	mapper, err := NewMap(strings.NewReader(verify.Metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := Check(db, b.dialect, *mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Error() != "table person: missing table" {
+		t.Errorf("want missing table, got %v", errs)
+	}
+
+	if _, err := db.Exec(verify.CreateTableSQL); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(verify.CleanupSQL)
+	errs, err = Check(db, b.dialect, *mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.TrimSpace(verify.Expected), "\n", "; ", -1)
+	if len(errs) != 1 || errs[0].Error() != "table person: "+want {
+		t.Errorf("want %q, got %v", want, errs)
+	}
+}
+
+func TestImports(t *testing.T) {
+	mapper, err := NewMap(strings.NewReader(`[{"struct": "T", "table": "t", "columns": [
+		{"field": "ID", "column": "id", "type": "uuid", "pk": true, "go_type": "guuid.UUID", "import": "github.com/google/uuid"},
+		{"field": "Born", "column": "born", "type": "timestamp", "null": true},
+		{"field": "Cents", "column": "cents", "go_type": "money.Cents", "import": "example.com/money", "finder": "one"}
+	]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, spec := range mapper.Imports() {
+		got = append(got, spec.String())
+	}
+	want := []string{`"context"`, `"database/sql"`, `"fmt"`, `"time"`, `"example.com/money"`, `guuid "github.com/google/uuid"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestFinderJSON(t *testing.T) {
+	var tests = []struct {
+		json string
+		want Finder
+		err  bool
+	}{
+		{`true`, FinderDefault, false},
+		{`false`, FinderNone, false},
+		{`"one"`, FinderOne, false},
+		{`"many"`, FinderMany, false},
+		{`"all"`, "", true},
+		{`1`, "", true},
+	}
+	for _, test := range tests {
+		var f Finder
+		err := json.Unmarshal([]byte(test.json), &f)
+		if (err != nil) != test.err {
+			t.Errorf("%s: want error %v, got %v", test.json, test.err, err)
+			continue
+		}
+		if f != test.want {
+			t.Errorf("%s: want %q, got %q", test.json, test.want, f)
+		}
+	}
+	col := ColumnMap{Field: "Email", Column: "email", Unique: true}
+	if err := json.Unmarshal([]byte(`true`), &col.Finder); err != nil {
+		t.Fatal(err)
+	}
+	if got := col.FinderName(); got != "FindOneByEmail" {
+		t.Errorf("unique column with finder true: want FindOneByEmail, got %s", got)
+	}
+}
+
+func TestDefaultFinders(t *testing.T) {
+	tm := TableMap{
+		Table:   "t",
+		Version: "Rev",
+		Columns: []ColumnMap{
+			{Field: "ID", Column: "id", Type: "integer", PrimaryKey: true},
+			{Field: "Name", Column: "name", Type: "text"},
+			{Field: "Rev", Column: "rev", Type: "integer"},
+			{Field: "Data", Column: "data", Type: "bytea"},
+			{Field: "Hash", Column: "hash", Type: "blob", Finder: FinderOne},
+		},
+	}
+	var got []string
+	for _, col := range tm.Finders() {
+		got = append(got, col.FinderName())
+	}
+	if want := []string{"FindByName", "FindOneByHash"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want finders %v, got %v", want, got)
+	}
+	tm.Columns[2].Finder = FinderMany
+	if got := tm.Finders(); len(got) != 3 || got[1].FinderName() != "FindByRev" {
+		t.Errorf("version column with explicit finder: got %v", got)
 	}
 }
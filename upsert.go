@@ -0,0 +1,202 @@
+package tablestruct
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Upsert describes the generated Upsert method, which inserts a row or, if it
+// conflicts with an existing one, updates that row instead. The method is only
+// generated for tables whose mapping metadata has upsert options, since the
+// conflict target must be a key the database enforces.
+type Upsert struct {
+	// Conflict are the names of the columns whose values identify the
+	// existing row: the primary key, a unique column, or the columns of a
+	// unique index. If empty, they are those of the primary key, which must
+	// not be generated by the database. MySQL has no conflict target, and
+	// updates the row of whichever unique key conflicts.
+	Conflict []string `json:"conflict,omitempty"`
+	// Update are the names of the columns set to the new values on conflict.
+	// If empty, they are all the inserted columns not in Conflict. A version
+	// column is not among them, but is incremented, and neither is a soft
+	// delete column, which is cleared to restore a soft-deleted row.
+	Update []string `json:"update,omitempty"`
+}
+
+// upsertColumns returns the columns of the conflict target of the Upsert
+// method and those it updates on conflict, or false if the mapper has no
+// Upsert method. The options are assumed to have been checked by
+// validateUpsert.
+func (t TableMap) upsertColumns() (conflict, update []ColumnMap, ok bool) {
+	if t.Upsert == nil {
+		return nil, nil, false
+	}
+	opts := *t.Upsert
+	if len(opts.Conflict) == 0 {
+		conflict = t.PrimaryKeys()
+	}
+	for _, name := range opts.Conflict {
+		conflict = append(conflict, *t.columnNamed(name))
+	}
+	for _, name := range opts.Update {
+		update = append(update, *t.columnNamed(name))
+	}
+	if len(opts.Update) == 0 {
+		for _, col := range t.Columns {
+			if !(t.AutoPK && col.PrimaryKey) && t.updated(col) && !containsColumn(conflict, col.Column) {
+				update = append(update, col)
+			}
+		}
+	}
+	if len(update) == 0 && t.Version == "" {
+		// Setting a column to its own value is a no-op that still hands
+		// back the conflicting row.
+		update = conflict[:1]
+	}
+	return conflict, update, true
+}
+
+// validateUpsert checks the upsert options of the table mapping for errors.
+func (t TableMap) validateUpsert() *MapError {
+	if t.Upsert == nil {
+		return nil
+	}
+	upsertErr := func(format string, args ...interface{}) *MapError {
+		return &MapError{Struct: t.Struct, Column: -1, Err: fmt.Errorf("upsert: "+format, args...)}
+	}
+	switch {
+	case t.PrimaryKey() == nil:
+		return upsertErr("no primary key column")
+	case len(t.InsertFields()) == 0:
+		return upsertErr("no inserted columns")
+	case len(t.Upsert.Conflict) == 0 && t.AutoPK:
+		return upsertErr("auto_pk set, so conflict columns are needed")
+	}
+	for _, names := range [][]string{t.Upsert.Conflict, t.Upsert.Update} {
+		for _, name := range names {
+			col := t.columnNamed(name)
+			switch {
+			case col == nil:
+				return upsertErr("column %s is not mapped", name)
+			case t.AutoPK && col.PrimaryKey:
+				return upsertErr("column %s is generated by the database", name)
+			case col.Field == t.Version:
+				return upsertErr("column %s is the version column", name)
+			case col.Column == t.SoftDelete:
+				return upsertErr("column %s is the soft delete column", name)
+			}
+		}
+	}
+	for _, name := range t.Upsert.Update {
+		if contains(t.Upsert.Conflict, name) {
+			return upsertErr("column %s is both a conflict and an update column", name)
+		}
+	}
+	if len(t.Upsert.Conflict) > 0 && !t.uniqueKey(t.Upsert.Conflict) {
+		return upsertErr("conflict columns %s are not the primary key, a unique column or a unique index", strings.Join(t.Upsert.Conflict, ", "))
+	}
+	return nil
+}
+
+// uniqueKey reports whether the named columns are, in any order, the primary
+// key, a unique column, or the columns of a unique index of the table.
+func (t TableMap) uniqueKey(columns []string) bool {
+	keys := [][]string{nil}
+	for _, pk := range t.PrimaryKeys() {
+		keys[0] = append(keys[0], pk.Column)
+	}
+	for _, col := range t.Columns {
+		if col.Unique {
+			keys = append(keys, []string{col.Column})
+		}
+	}
+	for _, idx := range t.Indexes {
+		if idx.Unique {
+			keys = append(keys, idx.Columns)
+		}
+	}
+	for _, key := range keys {
+		if len(key) != len(columns) {
+			continue
+		}
+		same := true
+		for _, col := range columns {
+			same = same && contains(key, col)
+		}
+		if same {
+			return true
+		}
+	}
+	return false
+}
+
+func containsColumn(cols []ColumnMap, column string) bool {
+	for i := range cols {
+		if cols[i].Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// upsertClause produces the SQL following the VALUES of an INSERT statement
+// into table that updates the conflicting row instead, incrementing its
+// version column if not nil and restoring it if softDelete names its soft
+// delete column.
+func upsertClause(table string, conflict, update []ColumnMap, version *ColumnMap, softDelete string, d Dialect) string {
+	var sets, target []string
+	for _, col := range update {
+		c := d.Quote(col.Column)
+		if d == MySQL {
+			sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", c, c))
+		} else {
+			sets = append(sets, fmt.Sprintf("%s = excluded.%s", c, c))
+		}
+	}
+	if version != nil {
+		c := d.Quote(version.Column)
+		sets = append(sets, fmt.Sprintf("%s = %s.%s + 1", c, d.Quote(table), c))
+	}
+	if softDelete != "" {
+		sets = append(sets, d.Quote(softDelete)+" = NULL")
+	}
+	if d == MySQL {
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+	for _, col := range conflict {
+		target = append(target, d.Quote(col.Column))
+	}
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(target, ", "), strings.Join(sets, ", "))
+}
+
+// upsert produces the statements of the Upsert method, or nil if the mapper
+// has none.
+func upsert(mapper TableMap, d Dialect) *upsertTmpl {
+	conflict, update, ok := mapper.upsertColumns()
+	if !ok {
+		return nil
+	}
+	clause := upsertClause(mapper.Table, conflict, update, mapper.versionColumn(), mapper.SoftDelete, d)
+	returning := ""
+	if d.Returning() {
+		returning = " RETURNING " + mapper.returningColumnList(d)
+	}
+	u := &upsertTmpl{
+		SQL:    fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)%s%s", d.Quote(mapper.Table), mapper.InsertColumnList(d), mapper.InsertList(d), clause, returning),
+		Suffix: clause + returning,
+	}
+	var (
+		conds   []string
+		args    []string
+		refKeys []string
+	)
+	for i, col := range conflict {
+		conds = append(conds, fmt.Sprintf("%s = %s", d.Quote(col.Column), d.Placeholder(i+1)))
+		args = append(args, "obj."+col.Field)
+		refKeys = append(refKeys, "refKey(obj."+col.Field+")")
+	}
+	u.KeySQL = fmt.Sprintf("SELECT %s FROM %s WHERE %s", mapper.returningColumnList(d), d.Quote(mapper.Table), strings.Join(conds, " AND "))
+	u.KeyArgs = strings.Join(args, ", ")
+	u.RefKeys = strings.Join(refKeys, ", ")
+	return u
+}